@@ -0,0 +1,140 @@
+// Package mux 在一个 xtcp.Conn 上复用多个双向的逻辑流，每个流都实现了 net.Conn，类似 yamux
+//
+//	session := mux.Client(conn)
+//	stream, err := session.Open()
+//
+// 每个流有独立的接收窗口，接收方读取数据后才会扩大对端的发送窗口，一个流读取缓慢不会阻塞其他流
+// 客户端使用奇数流 ID，服务端使用偶数流 ID
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/motai3/xtcp"
+)
+
+// 帧类型
+const (
+	typeData         byte = iota // 数据，消息体为流的数据
+	typeWindowUpdate             // 扩大对端的发送窗口，value 为增加的字节数
+	typePing                     // 心跳，value 为对端需要原样返回的值
+	typeGoAway                   // 会话即将关闭，value 为原因
+)
+
+// 帧标志位
+const (
+	flagSYN byte = 1 << iota // 打开新的流
+	flagACK                  // 确认打开流，或者是 ping 的响应
+	flagFIN                  // 发送方不再发送数据
+	flagRST                  // 立即关闭流
+)
+
+// 帧头为 类型(1 字节) + 标志位(1 字节) + 流 ID(4 字节) + value(4 字节)，外层仍然是 pkg 的长度前缀
+const headerSize = 10
+
+const (
+	goAwayNormal        uint32 = iota // 正常关闭
+	goAwayProtocolError               // 对端违反协议
+)
+
+// Config 会话参数，两端需要使用相同的 PkgOption 和 MaxStreamWindow
+type Config struct {
+	PkgOption         xtcp.PkgOption // 零值时使用 4 字节长度头
+	AcceptBacklog     int            // 等待 Accept 的流数量，超过时拒绝对端打开的流，默认 256
+	MaxStreamWindow   uint32         // 每个流的接收窗口，默认 256KB
+	MaxFrameSize      int            // 一帧最多携带的数据，默认 32KB
+	KeepAliveInterval time.Duration  // 发送 ping 的间隔，默认 30 秒，小于 0 时关闭心跳
+	KeepAliveTimeout  time.Duration  // 超过该时间没有读到任何数据时关闭会话，默认 3 倍 KeepAliveInterval
+}
+
+var (
+	// ErrSessionShutdown 会话已经关闭
+	ErrSessionShutdown = errors.New("mux: session shutdown")
+	// ErrStreamClosed 流的写方向已经关闭
+	ErrStreamClosed = errors.New("mux: stream closed")
+	// ErrStreamReset 流被对端或者本端重置
+	ErrStreamReset = errors.New("mux: stream reset")
+	// ErrKeepAliveTimeout 对端在 KeepAliveTimeout 时间内没有发送任何数据
+	ErrKeepAliveTimeout = errors.New("mux: keepalive timeout")
+	// ErrRemoteGoAway 对端已经关闭会话，不能再打开新的流
+	ErrRemoteGoAway = errors.New("mux: remote end is not accepting connections")
+	// ErrTimeout 读写超过了流的 deadline
+	ErrTimeout error = timeoutError{}
+
+	errProtocol = errors.New("mux: protocol error")
+)
+
+const (
+	defaultAcceptBacklog     = 256
+	defaultMaxStreamWindow   = 256 * 1024
+	defaultMaxFrameSize      = 32 * 1024
+	defaultKeepAliveInterval = 30 * time.Second
+	// 等待写入的控制帧数量，超过时读取协程等待
+	controlBacklog = 64
+)
+
+// timeoutError 实现 net.Error，便于调用方使用 Timeout 判断
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "mux: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// frame 解析后的一帧
+type frame struct {
+	typ      byte
+	flags    byte
+	streamId uint32
+	value    uint32
+	data     []byte
+}
+
+func getConfig(config ...Config) Config {
+	c := Config{}
+	if len(config) > 0 {
+		c = config[0]
+	}
+	if c.PkgOption.HeaderSize == 0 && !c.PkgOption.Varint {
+		c.PkgOption.HeaderSize = 4
+	}
+	if c.AcceptBacklog <= 0 {
+		c.AcceptBacklog = defaultAcceptBacklog
+	}
+	if c.MaxStreamWindow == 0 {
+		c.MaxStreamWindow = defaultMaxStreamWindow
+	}
+	if c.MaxFrameSize <= 0 {
+		c.MaxFrameSize = defaultMaxFrameSize
+	}
+	if c.KeepAliveInterval == 0 {
+		c.KeepAliveInterval = defaultKeepAliveInterval
+	}
+	if c.KeepAliveTimeout <= 0 {
+		c.KeepAliveTimeout = 3 * c.KeepAliveInterval
+	}
+	return c
+}
+
+func packFrame(typ, flags byte, streamId, value uint32, data []byte) []byte {
+	buffer := make([]byte, headerSize, headerSize+len(data))
+	buffer[0] = typ
+	buffer[1] = flags
+	binary.BigEndian.PutUint32(buffer[2:], streamId)
+	binary.BigEndian.PutUint32(buffer[6:], value)
+	return append(buffer, data...)
+}
+
+func unpackFrame(data []byte) (*frame, error) {
+	if len(data) < headerSize {
+		return nil, errProtocol
+	}
+	return &frame{
+		typ:      data[0],
+		flags:    data[1],
+		streamId: binary.BigEndian.Uint32(data[2:]),
+		value:    binary.BigEndian.Uint32(data[6:]),
+		data:     data[headerSize:],
+	}, nil
+}
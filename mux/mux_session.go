@@ -0,0 +1,343 @@
+package mux
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/motai3/xtcp"
+)
+
+// Session 一个连接上的多路复用会话，实现了 net.Listener，可以直接交给 http.Server 等使用
+type Session struct {
+	conn       *xtcp.Conn
+	config     Config
+	mu         sync.Mutex
+	nextId     uint32
+	streams    map[uint32]*Stream
+	acceptCh   chan *Stream
+	controlCh  chan []byte // 等待 sendLoop 写入的控制帧
+	remoteAway bool        // 收到了对端的 GoAway
+	localAway  bool        // 已经发送 GoAway
+	closed     chan struct{}
+	closeErr   error
+	closeOnce  sync.Once
+}
+
+// Client 创建客户端会话，打开的流使用奇数 ID
+func Client(conn *xtcp.Conn, config ...Config) *Session {
+	return newSession(conn, true, getConfig(config...))
+}
+
+// Server 创建服务端会话，打开的流使用偶数 ID
+func Server(conn *xtcp.Conn, config ...Config) *Session {
+	return newSession(conn, false, getConfig(config...))
+}
+
+func newSession(conn *xtcp.Conn, client bool, config Config) *Session {
+	s := &Session{
+		conn:      conn,
+		config:    config,
+		nextId:    2,
+		streams:   make(map[uint32]*Stream),
+		acceptCh:  make(chan *Stream, config.AcceptBacklog),
+		controlCh: make(chan []byte, controlBacklog),
+		closed:    make(chan struct{}),
+	}
+	if client {
+		s.nextId = 1
+	}
+	go s.recvLoop()
+	go s.sendLoop()
+	if config.KeepAliveInterval > 0 {
+		go s.keepalive()
+	}
+	return s
+}
+
+// Open 打开一个新的流，不等待对端确认，对端拒绝时流的读写返回 ErrStreamReset
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return nil, ErrSessionShutdown
+	}
+	if s.remoteAway {
+		s.mu.Unlock()
+		return nil, ErrRemoteGoAway
+	}
+	id := s.nextId
+	if id > id+2 {
+		s.mu.Unlock()
+		return nil, ErrSessionShutdown
+	}
+	s.nextId += 2
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.mu.Unlock()
+	// 通过窗口更新帧通知对端打开流，窗口增量为 0
+	if err := s.send(typeWindowUpdate, flagSYN, id, 0, nil); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// OpenConn 与 Open 相同，返回 net.Conn
+func (s *Session) OpenConn() (net.Conn, error) {
+	return s.Open()
+}
+
+// AcceptStream 等待对端打开的流
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-s.acceptCh:
+		if err := s.send(typeWindowUpdate, flagACK, stream.id, 0, nil); err != nil {
+			return nil, err
+		}
+		return stream, nil
+	case <-s.closed:
+		return nil, s.err()
+	}
+}
+
+// Accept 实现 net.Listener
+func (s *Session) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// Addr 实现 net.Listener，返回底层连接的本地地址
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// NumStreams 返回当前打开的流数量
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// GoAway 通知对端不再接受新的流，已经打开的流不受影响
+func (s *Session) GoAway() error {
+	s.mu.Lock()
+	s.localAway = true
+	s.mu.Unlock()
+	return s.send(typeGoAway, 0, 0, goAwayNormal, nil)
+}
+
+// Close 关闭会话和底层连接，所有流的读写返回 ErrSessionShutdown
+func (s *Session) Close() error {
+	return s.shutdown(nil)
+}
+
+// CloseChan 在会话关闭时关闭
+func (s *Session) CloseChan() <-chan struct{} {
+	return s.closed
+}
+
+// IsClosed 返回会话是否已经关闭
+func (s *Session) IsClosed() bool {
+	return s.isClosed()
+}
+
+// Err 返回会话关闭的原因，主动关闭时为 ErrSessionShutdown
+func (s *Session) Err() error {
+	return s.err()
+}
+
+func (s *Session) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Session) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closeErr == nil {
+		return ErrSessionShutdown
+	}
+	return s.closeErr
+}
+
+func (s *Session) shutdown(reason error) error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closeErr = reason
+		if s.closeErr == nil {
+			s.closeErr = ErrSessionShutdown
+		}
+		if !s.localAway && reason == nil {
+			s.localAway = true
+			s.mu.Unlock()
+			// 不能使用 send，发送失败时会再次调用 shutdown
+			s.conn.SendPkg(packFrame(typeGoAway, 0, 0, goAwayNormal, nil), s.config.PkgOption)
+			s.mu.Lock()
+		}
+		close(s.closed)
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+		for _, stream := range streams {
+			stream.notify()
+		}
+		if reason != nil {
+			err = s.conn.CloseWithReason(reason)
+		} else {
+			err = s.conn.Close()
+		}
+	})
+	return err
+}
+
+func (s *Session) send(typ, flags byte, streamId, value uint32, data []byte) error {
+	if err := s.conn.SendPkg(packFrame(typ, flags, streamId, value, data), s.config.PkgOption); err != nil {
+		s.shutdown(err)
+		return err
+	}
+	return nil
+}
+
+// sendControl 把不带数据的控制帧交给 sendLoop 发送，不等待写入连接，队列满时等待
+// 读取协程通过它回复对端，对端不读取时不会阻塞读取，避免两端都在等待对方读取
+func (s *Session) sendControl(typ, flags byte, streamId, value uint32) error {
+	select {
+	case s.controlCh <- packFrame(typ, flags, streamId, value, nil):
+		return nil
+	case <-s.closed:
+		return s.err()
+	}
+}
+
+// sendLoop 依次写入 sendControl 放入队列的控制帧
+func (s *Session) sendLoop() {
+	for {
+		select {
+		case data := <-s.controlCh:
+			if err := s.conn.SendPkg(data, s.config.PkgOption); err != nil {
+				s.shutdown(err)
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// recvLoop 读取帧并分发到对应的流
+func (s *Session) recvLoop() {
+	for {
+		data, err := s.conn.RecvPkg(s.config.PkgOption)
+		var f *frame
+		if err == nil {
+			f, err = unpackFrame(data)
+		}
+		if err == nil {
+			err = s.handle(f)
+		}
+		if err != nil {
+			if err == errProtocol {
+				s.send(typeGoAway, 0, 0, goAwayProtocolError, nil)
+			}
+			s.shutdown(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handle(f *frame) error {
+	switch f.typ {
+	case typeData, typeWindowUpdate:
+		return s.handleStream(f)
+	case typePing:
+		if f.flags&flagSYN != 0 {
+			return s.sendControl(typePing, flagACK, 0, f.value)
+		}
+		return nil
+	case typeGoAway:
+		s.mu.Lock()
+		s.remoteAway = true
+		s.mu.Unlock()
+		if f.value == goAwayProtocolError {
+			return errProtocol
+		}
+		return nil
+	default:
+		return errProtocol
+	}
+}
+
+func (s *Session) handleStream(f *frame) error {
+	s.mu.Lock()
+	stream, ok := s.streams[f.streamId]
+	if f.flags&flagSYN != 0 {
+		if ok || f.streamId == 0 || f.streamId%2 == s.nextId%2 {
+			s.mu.Unlock()
+			return errProtocol
+		}
+		if s.localAway {
+			s.mu.Unlock()
+			return s.sendControl(typeWindowUpdate, flagRST, f.streamId, 0)
+		}
+		stream = newStream(s, f.streamId)
+		select {
+		case s.acceptCh <- stream:
+		default:
+			// 等待 Accept 的流已满，拒绝对端
+			s.mu.Unlock()
+			return s.sendControl(typeWindowUpdate, flagRST, f.streamId, 0)
+		}
+		s.streams[f.streamId] = stream
+		ok = true
+	}
+	s.mu.Unlock()
+	if !ok {
+		// 已经关闭的流可能还会收到对端在关闭之前发出的帧，直接丢弃
+		return nil
+	}
+	if f.typ == typeWindowUpdate {
+		stream.incrSendWindow(f.value)
+	} else if err := stream.receive(f.data); err != nil {
+		return err
+	}
+	if f.flags&flagFIN != 0 {
+		stream.remoteClose()
+	}
+	if f.flags&flagRST != 0 {
+		stream.reset()
+	}
+	return nil
+}
+
+// keepalive 定时发送 ping，超过 KeepAliveTimeout 没有读到数据时关闭会话
+func (s *Session) keepalive() {
+	ticker := time.NewTicker(s.config.KeepAliveInterval)
+	defer ticker.Stop()
+	var seq uint32
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.closed:
+			return
+		}
+		if time.Since(s.conn.LastRead()) >= s.config.KeepAliveTimeout {
+			s.shutdown(ErrKeepAliveTimeout)
+			return
+		}
+		seq++
+		if s.send(typePing, flagSYN, 0, seq, nil) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream 会话中的一个逻辑流，实现了 net.Conn
+type Stream struct {
+	id            uint32
+	session       *Session
+	mu            sync.Mutex
+	recvBuf       bytes.Buffer
+	recvWindow    uint32 // 对端还可以发送的字节数
+	unacked       uint32 // 已经读取但还没有通知对端的字节数
+	sendWindow    uint32 // 还可以发送的字节数
+	localClosed   bool   // 已经发送 FIN
+	remoteClosed  bool   // 已经收到 FIN
+	isReset       bool
+	recvNotify    chan struct{}
+	sendNotify    chan struct{}
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		id:         id,
+		session:    session,
+		recvWindow: session.config.MaxStreamWindow,
+		sendWindow: session.config.MaxStreamWindow,
+		recvNotify: make(chan struct{}, 1),
+		sendNotify: make(chan struct{}, 1),
+	}
+}
+
+// ID 返回流 ID
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// Session 返回流所属的会话
+func (s *Stream) Session() *Session {
+	return s.session
+}
+
+// Read 读取对端发送的数据，对端关闭写方向后返回 io.EOF
+func (s *Stream) Read(b []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.isReset {
+			s.mu.Unlock()
+			return 0, ErrStreamReset
+		}
+		if s.recvBuf.Len() > 0 {
+			n, _ := s.recvBuf.Read(b)
+			// 读取超过窗口的一半时再通知对端，减少窗口更新帧的数量
+			s.unacked += uint32(n)
+			var delta uint32
+			if s.unacked >= s.session.config.MaxStreamWindow/2 && !s.remoteClosed {
+				delta = s.unacked
+				s.unacked = 0
+				s.recvWindow += delta
+			}
+			s.mu.Unlock()
+			if delta > 0 {
+				s.session.sendControl(typeWindowUpdate, 0, s.id, delta)
+			}
+			return n, nil
+		}
+		remoteClosed, deadline := s.remoteClosed, s.readDeadline
+		s.mu.Unlock()
+		if remoteClosed {
+			return 0, io.EOF
+		}
+		if err := s.wait(s.recvNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write 写入数据，发送窗口用完时等待对端读取
+func (s *Stream) Write(b []byte) (int, error) {
+	var written int
+	for written < len(b) {
+		s.mu.Lock()
+		switch {
+		case s.isReset:
+			s.mu.Unlock()
+			return written, ErrStreamReset
+		case s.localClosed:
+			s.mu.Unlock()
+			return written, ErrStreamClosed
+		}
+		if s.sendWindow == 0 {
+			deadline := s.writeDeadline
+			s.mu.Unlock()
+			if err := s.wait(s.sendNotify, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := len(b) - written
+		if n > int(s.sendWindow) {
+			n = int(s.sendWindow)
+		}
+		if n > s.session.config.MaxFrameSize {
+			n = s.session.config.MaxFrameSize
+		}
+		s.sendWindow -= uint32(n)
+		s.mu.Unlock()
+		if err := s.session.send(typeData, 0, s.id, 0, b[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close 关闭写方向并通知对端，对端仍然可以继续发送数据直到也关闭
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.localClosed || s.isReset {
+		s.mu.Unlock()
+		return nil
+	}
+	s.localClosed = true
+	done := s.remoteClosed
+	s.mu.Unlock()
+	if done {
+		s.session.remove(s.id)
+	}
+	s.notifySend()
+	if s.session.isClosed() {
+		return nil
+	}
+	return s.session.send(typeWindowUpdate, flagFIN, s.id, 0, nil)
+}
+
+// Reset 立即关闭流，未读取和未发送的数据都会被丢弃
+func (s *Stream) Reset() error {
+	s.mu.Lock()
+	if s.isReset {
+		s.mu.Unlock()
+		return nil
+	}
+	s.isReset = true
+	s.mu.Unlock()
+	s.session.remove(s.id)
+	s.notify()
+	if s.session.isClosed() {
+		return nil
+	}
+	return s.session.send(typeWindowUpdate, flagRST, s.id, 0, nil)
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return s.session.conn.LocalAddr()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.session.conn.RemoteAddr()
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	s.SetWriteDeadline(t)
+	return nil
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	s.mu.Unlock()
+	s.notifyRecv()
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.writeDeadline = t
+	s.mu.Unlock()
+	s.notifySend()
+	return nil
+}
+
+// wait 等待 notify、deadline 或者会话关闭
+func (s *Stream) wait(notify chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return ErrTimeout
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-notify:
+		return nil
+	case <-timeout:
+		return ErrTimeout
+	case <-s.session.closed:
+		return s.session.err()
+	}
+}
+
+// receive 保存对端发送的数据，超过接收窗口时返回协议错误
+func (s *Stream) receive(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	if uint32(len(data)) > s.recvWindow {
+		s.mu.Unlock()
+		return errProtocol
+	}
+	s.recvWindow -= uint32(len(data))
+	if !s.isReset {
+		s.recvBuf.Write(data)
+	}
+	s.mu.Unlock()
+	s.notifyRecv()
+	return nil
+}
+
+func (s *Stream) incrSendWindow(delta uint32) {
+	if delta == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.sendWindow += delta
+	s.mu.Unlock()
+	s.notifySend()
+}
+
+// remoteClose 收到对端的 FIN
+func (s *Stream) remoteClose() {
+	s.mu.Lock()
+	s.remoteClosed = true
+	done := s.localClosed
+	s.mu.Unlock()
+	if done {
+		s.session.remove(s.id)
+	}
+	s.notifyRecv()
+}
+
+// reset 收到对端的 RST
+func (s *Stream) reset() {
+	s.mu.Lock()
+	s.isReset = true
+	s.mu.Unlock()
+	s.session.remove(s.id)
+	s.notify()
+}
+
+func (s *Stream) notify() {
+	s.notifyRecv()
+	s.notifySend()
+}
+
+func (s *Stream) notifyRecv() {
+	select {
+	case s.recvNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Stream) notifySend() {
+	select {
+	case s.sendNotify <- struct{}{}:
+	default:
+	}
+}
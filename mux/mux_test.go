@@ -0,0 +1,221 @@
+package mux_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"github.com/motai3/xtcp"
+	"github.com/motai3/xtcp/container/xlist"
+	"github.com/motai3/xtcp/mux"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var portList = xlist.New()
+
+func init() {
+	// 与其他包的测试并行运行，使用不同的端口
+	for i := 10100; i < 10200; i++ {
+		portList.PushBack(i)
+	}
+}
+
+// newTestSession 启动服务端会话，handler 处理每个被接受的流，返回客户端会话
+func newTestSession(t *testing.T, config mux.Config, handler func(stream *mux.Stream)) *mux.Session {
+	p := portList.PopFront().(int)
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		session := mux.Server(conn, config)
+		defer session.Close()
+		for {
+			stream, err := session.AcceptStream()
+			if err != nil {
+				return
+			}
+			go handler(stream)
+		}
+	})
+	go server.Run()
+	t.Cleanup(func() {
+		server.Close()
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	session := mux.Client(conn, config)
+	t.Cleanup(func() {
+		session.Close()
+	})
+	return session
+}
+
+func Test_Mux_Echo(t *testing.T) {
+	session := newTestSession(t, mux.Config{}, func(stream *mux.Stream) {
+		io.Copy(stream, stream)
+		stream.Close()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := session.Open()
+			assert.NoError(t, err)
+			// 超过接收窗口的数据需要依靠窗口更新才能发完
+			data := make([]byte, 1024*1024)
+			rand.Read(data)
+			go func() {
+				stream.Write(data)
+				stream.Close()
+			}()
+			result, err := io.ReadAll(stream)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data, result))
+		}()
+	}
+	wg.Wait()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, session.NumStreams())
+}
+
+func Test_Mux_FlowControl(t *testing.T) {
+	accepted := make(chan *mux.Stream, 1)
+	config := mux.Config{MaxStreamWindow: 64 * 1024}
+	session := newTestSession(t, config, func(stream *mux.Stream) {
+		accepted <- stream
+	})
+
+	stream, err := session.Open()
+	assert.NoError(t, err)
+	// 对端不读取，写满窗口后超时
+	stream.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	n, err := stream.Write(make([]byte, 128*1024))
+	assert.Equal(t, mux.ErrTimeout, err)
+	assert.Equal(t, 64*1024, n)
+
+	// 对端读取后窗口恢复
+	peer := <-accepted
+	go io.Copy(io.Discard, peer)
+	stream.SetWriteDeadline(time.Now().Add(time.Second))
+	n, err = stream.Write(make([]byte, 128*1024))
+	assert.NoError(t, err)
+	assert.Equal(t, 128*1024, n)
+}
+
+func Test_Mux_Reset(t *testing.T) {
+	session := newTestSession(t, mux.Config{}, func(stream *mux.Stream) {
+		stream.Reset()
+	})
+
+	stream, err := session.Open()
+	assert.NoError(t, err)
+	_, err = stream.Read(make([]byte, 1))
+	assert.Equal(t, mux.ErrStreamReset, err)
+	_, err = stream.Write([]byte("a"))
+	assert.Equal(t, mux.ErrStreamReset, err)
+}
+
+func Test_Mux_Close(t *testing.T) {
+	session := newTestSession(t, mux.Config{}, func(stream *mux.Stream) {})
+
+	stream, err := session.Open()
+	assert.NoError(t, err)
+	result := make(chan error)
+	go func() {
+		_, err := stream.Read(make([]byte, 1))
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, session.Close())
+	assert.Equal(t, mux.ErrSessionShutdown, <-result)
+	_, err = session.Open()
+	assert.Equal(t, mux.ErrSessionShutdown, err)
+}
+
+func Test_Mux_KeepAlive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		// 接受连接后不做任何响应
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(2 * time.Second)
+		}
+	}()
+
+	conn, err := xtcp.NewConn(listener.Addr().String())
+	assert.NoError(t, err)
+	session := mux.Client(conn, mux.Config{
+		KeepAliveInterval: 20 * time.Millisecond,
+		KeepAliveTimeout:  100 * time.Millisecond,
+	})
+	defer session.Close()
+	select {
+	case <-session.CloseChan():
+		assert.Equal(t, mux.ErrKeepAliveTimeout, session.Err())
+	case <-time.After(time.Second):
+		t.Fatal("keepalive timeout is not detected")
+	}
+}
+
+func Test_Mux_NoDeadlock(t *testing.T) {
+	// net.Pipe 没有缓冲，读取协程阻塞在写入 ping 响应或窗口更新上时两端会互相等待
+	client, server := net.Pipe()
+	config := mux.Config{
+		MaxStreamWindow:   64 * 1024,
+		MaxFrameSize:      4 * 1024,
+		KeepAliveInterval: time.Millisecond,
+		KeepAliveTimeout:  10 * time.Second,
+	}
+	a := mux.Client(xtcp.NewConnByNetConn(client), config)
+	defer a.Close()
+	b := mux.Server(xtcp.NewConnByNetConn(server), config)
+	defer b.Close()
+	// 死锁时先关闭底层连接，让阻塞的写入返回
+	defer server.Close()
+	defer client.Close()
+
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	// 两端同时写入和读取
+	echo := func(stream *mux.Stream) {
+		go func() {
+			stream.Write(data)
+			stream.Close()
+		}()
+		result, err := io.ReadAll(stream)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(data, result))
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if stream, err := b.AcceptStream(); assert.NoError(t, err) {
+			echo(stream)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if stream, err := a.Open(); assert.NoError(t, err) {
+			echo(stream)
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sessions are deadlocked")
+	}
+}
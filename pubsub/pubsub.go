@@ -0,0 +1,194 @@
+// Package pubsub 基于 xtcp pkg 协议的主题发布订阅，Broker 可以直接嵌入已有的 xtcp 服务
+//
+//	broker := pubsub.NewBroker()
+//	xtcp.NewServer(":8999", broker.ServeConn).Run()
+//
+// 主题由 "." 分隔的若干段组成，例如 "order.created.cn"，订阅时可以使用通配符：
+// "*" 匹配任意一段，">" 只能作为最后一段，匹配剩余的一段或多段
+//
+//	"order.*"   匹配 "order.created"，不匹配 "order.created.cn"
+//	"order.>"   匹配 "order.created" 和 "order.created.cn"
+//
+// 每个订阅者（连接）有一个长度有限的发送队列，队列满时按照 Option.SlowConsumer 处理，一个慢速的订阅者不会阻塞发布者
+package pubsub
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/motai3/xtcp"
+)
+
+// 消息类型
+const (
+	kindSubscribe   byte = iota + 1 // 订阅，topic 为订阅模式
+	kindUnsubscribe                 // 取消订阅
+	kindPublish                     // 发布消息
+	kindMessage                     // 服务端推送给订阅者的消息
+	kindSubscribed                  // 订阅成功
+	kindError                       // 订阅失败，消息体为错误信息
+)
+
+// 消息头为 类型(1 字节) + 订阅 ID(4 字节) + 主题长度(2 字节)，之后为主题和消息体
+// 客户端使用 xtcp.Client 收发消息，每条消息前面还有 4 字节的请求 ID：订阅为请求，服务端的确认为响应，
+// 发布和取消订阅不需要响应，ID 为 0，服务端推送给订阅者的消息也使用 ID 0
+const (
+	headerSize = 7
+	callIdSize = 4
+)
+
+// SlowConsumerPolicy 订阅者的发送队列满时的处理方式
+type SlowConsumerPolicy int
+
+const (
+	DropNewest SlowConsumerPolicy = iota // 丢弃新的消息
+	DropOldest                           // 丢弃队列中最早的消息
+	Disconnect                           // 断开订阅者的连接
+)
+
+// Option 客户端和服务端的参数，两端需要使用相同的 PkgOption
+type Option struct {
+	PkgOption      xtcp.PkgOption        // 零值时使用 4 字节长度头
+	QueueSize      int                   // 服务端每个订阅者的发送队列长度，以及客户端等待 handler 处理的消息数量，默认 1024
+	SlowConsumer   SlowConsumerPolicy    // 发送队列满时的处理方式，默认 DropNewest
+	OnSlowConsumer func(conn *xtcp.Conn) // 发送队列满时调用，丢弃消息时每丢弃一条调用一次
+	TLSConfig      *tls.Config           // Dial 使用 TLS 连接
+	Timeout        time.Duration         // Dial 的连接超时
+}
+
+// Message 订阅者收到的消息
+type Message struct {
+	Topic string
+	Data  []byte
+}
+
+// frame 解析后的一条消息
+type frame struct {
+	kind  byte
+	sid   uint32
+	topic string
+	body  []byte
+}
+
+var (
+	// ErrShutdown 连接已经关闭
+	ErrShutdown = errors.New("pubsub: connection is shut down")
+	// ErrInvalidTopic 发布的主题为空、包含空的段或者包含通配符
+	ErrInvalidTopic = errors.New("pubsub: invalid topic")
+	// ErrInvalidPattern 订阅模式为空、包含空的段或者 ">" 不在最后一段
+	ErrInvalidPattern = errors.New("pubsub: invalid pattern")
+	// ErrSlowConsumer 订阅者的发送队列已满，SlowConsumer 为 Disconnect 时作为连接关闭的原因
+	ErrSlowConsumer = errors.New("pubsub: slow consumer")
+
+	errFrameTooShort = errors.New("pubsub: frame is too short")
+)
+
+const defaultQueueSize = 1024
+
+func getOption(option ...Option) Option {
+	o := Option{}
+	if len(option) > 0 {
+		o = option[0]
+	}
+	if o.PkgOption.HeaderSize == 0 && !o.PkgOption.Varint {
+		o.PkgOption.HeaderSize = 4
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = defaultQueueSize
+	}
+	return o
+}
+
+// Match 判断主题是否匹配订阅模式
+func Match(pattern, topic string) bool {
+	return match(strings.Split(pattern, "."), strings.Split(topic, "."))
+}
+
+func match(pattern, topic []string) bool {
+	for i, p := range pattern {
+		if p == ">" {
+			return len(topic) > i
+		}
+		if i >= len(topic) || (p != "*" && p != topic[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
+
+// splitTopic 拆分发布的主题，主题不合法时返回 nil
+func splitTopic(topic string) []string {
+	if len(topic) > 0xFFFF {
+		return nil
+	}
+	segments := strings.Split(topic, ".")
+	for _, s := range segments {
+		if s == "" || s == "*" || s == ">" {
+			return nil
+		}
+	}
+	return segments
+}
+
+// splitPattern 拆分订阅模式，模式不合法时返回 nil
+func splitPattern(pattern string) []string {
+	if len(pattern) > 0xFFFF {
+		return nil
+	}
+	segments := strings.Split(pattern, ".")
+	for i, s := range segments {
+		if s == "" || (s == ">" && i != len(segments)-1) {
+			return nil
+		}
+	}
+	return segments
+}
+
+// isWildcard 判断订阅模式是否包含通配符
+func isWildcard(segments []string) bool {
+	for _, s := range segments {
+		if s == "*" || s == ">" {
+			return true
+		}
+	}
+	return false
+}
+
+func packFrame(kind byte, sid uint32, topic string, body []byte) []byte {
+	return appendFrame(make([]byte, 0, headerSize+len(topic)+len(body)), kind, sid, topic, body)
+}
+
+// packPush 编码服务端推送给订阅者的消息，前面为 xtcp.Client 推送使用的 ID 0
+func packPush(sid uint32, topic string, body []byte) []byte {
+	buffer := make([]byte, callIdSize, callIdSize+headerSize+len(topic)+len(body))
+	return appendFrame(buffer, kindMessage, sid, topic, body)
+}
+
+func appendFrame(dst []byte, kind byte, sid uint32, topic string, body []byte) []byte {
+	var header [headerSize]byte
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], sid)
+	binary.BigEndian.PutUint16(header[5:], uint16(len(topic)))
+	dst = append(dst, header[:]...)
+	dst = append(dst, topic...)
+	return append(dst, body...)
+}
+
+func unpackFrame(data []byte) (*frame, error) {
+	if len(data) < headerSize {
+		return nil, errFrameTooShort
+	}
+	size := int(binary.BigEndian.Uint16(data[5:]))
+	if len(data) < headerSize+size {
+		return nil, errFrameTooShort
+	}
+	return &frame{
+		kind:  data[0],
+		sid:   binary.BigEndian.Uint32(data[1:]),
+		topic: string(data[headerSize : headerSize+size]),
+		body:  data[headerSize+size:],
+	}, nil
+}
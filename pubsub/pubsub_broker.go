@@ -0,0 +1,256 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/motai3/xtcp"
+)
+
+// Broker 保存所有连接的订阅，ServeConn 可以直接作为 xtcp.Server 的 handler，TLS、心跳等由 xtcp.Server 配置
+type Broker struct {
+	option   Option
+	mu       sync.RWMutex
+	exact    map[string]map[*subscription]struct{} // 不含通配符的订阅，按主题索引
+	wildcard map[*subscription]struct{}            // 含通配符的订阅，发布时逐个匹配
+}
+
+// subscriber 一个连接上的订阅者，消息先放入发送队列，由单独的协程写入连接
+type subscriber struct {
+	broker *Broker
+	conn   *xtcp.Conn
+	queue  chan []byte
+	closed chan struct{}
+	subs   map[uint32]*subscription // 只在 ServeConn 的协程中访问
+	kick   sync.Once                // Disconnect 策略只断开一次
+}
+
+type subscription struct {
+	subscriber *subscriber
+	sid        uint32
+	pattern    string
+	segments   []string
+}
+
+// 写入协程一次最多合并发送的消息数量
+const maxBatchSize = 64
+
+var errDuplicateSubscription = errors.New("pubsub: duplicate subscription id")
+
+func NewBroker(option ...Option) *Broker {
+	return &Broker{
+		option:   getOption(option...),
+		exact:    make(map[string]map[*subscription]struct{}),
+		wildcard: make(map[*subscription]struct{}),
+	}
+}
+
+// ServeConn 处理一个连接上的订阅和发布，连接断开时取消该连接的所有订阅
+// 所有连接共用 Option，PkgOption.Cipher 不为 nil 时以 xtcp.ErrSharedCipher 为原因关闭连接
+func (b *Broker) ServeConn(conn *xtcp.Conn) {
+	if b.option.PkgOption.Cipher != nil {
+		conn.CloseWithReason(xtcp.ErrSharedCipher)
+		return
+	}
+	s := &subscriber{
+		broker: b,
+		conn:   conn,
+		queue:  make(chan []byte, b.option.QueueSize),
+		closed: make(chan struct{}),
+		subs:   make(map[uint32]*subscription),
+	}
+	go s.write()
+	defer s.close()
+	for {
+		id, data, err := conn.RecvCall(b.option.PkgOption)
+		if err != nil {
+			return
+		}
+		f, err := unpackFrame(data)
+		if err != nil {
+			return
+		}
+		switch f.kind {
+		case kindSubscribe:
+			// 注册之后再确认，客户端 Subscribe 返回后发布的消息都能收到
+			if err = s.subscribe(f.sid, f.topic); err != nil {
+				err = conn.SendReply(id, packFrame(kindError, f.sid, "", []byte(err.Error())), b.option.PkgOption)
+			} else {
+				err = conn.SendReply(id, packFrame(kindSubscribed, f.sid, "", nil), b.option.PkgOption)
+			}
+			if err != nil {
+				return
+			}
+		case kindUnsubscribe:
+			s.unsubscribe(f.sid)
+		case kindPublish:
+			// 客户端发布前已经检查过主题，不合法的主题直接丢弃
+			b.Publish(f.topic, f.body)
+		default:
+			return
+		}
+	}
+}
+
+// Publish 把消息发送给所有匹配的订阅，返回匹配的订阅数量，不等待消息写入连接
+func (b *Broker) Publish(topic string, data []byte) (int, error) {
+	segments := splitTopic(topic)
+	if segments == nil {
+		return 0, ErrInvalidTopic
+	}
+	b.mu.RLock()
+	matched := make([]*subscription, 0, len(b.exact[topic]))
+	for sub := range b.exact[topic] {
+		matched = append(matched, sub)
+	}
+	for sub := range b.wildcard {
+		if match(sub.segments, segments) {
+			matched = append(matched, sub)
+		}
+	}
+	b.mu.RUnlock()
+	for _, sub := range matched {
+		sub.subscriber.enqueue(packPush(sub.sid, topic, data))
+	}
+	return len(matched), nil
+}
+
+// NumSubscriptions 返回所有连接的订阅数量
+func (b *Broker) NumSubscriptions() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	n := len(b.wildcard)
+	for _, subs := range b.exact {
+		n += len(subs)
+	}
+	return n
+}
+
+func (b *Broker) add(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if isWildcard(sub.segments) {
+		b.wildcard[sub] = struct{}{}
+		return
+	}
+	subs, ok := b.exact[sub.pattern]
+	if !ok {
+		subs = make(map[*subscription]struct{})
+		b.exact[sub.pattern] = subs
+	}
+	subs[sub] = struct{}{}
+}
+
+func (b *Broker) remove(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if isWildcard(sub.segments) {
+		delete(b.wildcard, sub)
+		return
+	}
+	if subs, ok := b.exact[sub.pattern]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(b.exact, sub.pattern)
+		}
+	}
+}
+
+func (s *subscriber) subscribe(sid uint32, pattern string) error {
+	if _, ok := s.subs[sid]; ok {
+		return errDuplicateSubscription
+	}
+	segments := splitPattern(pattern)
+	if segments == nil {
+		return ErrInvalidPattern
+	}
+	sub := &subscription{
+		subscriber: s,
+		sid:        sid,
+		pattern:    pattern,
+		segments:   segments,
+	}
+	s.subs[sid] = sub
+	s.broker.add(sub)
+	return nil
+}
+
+func (s *subscriber) unsubscribe(sid uint32) {
+	if sub, ok := s.subs[sid]; ok {
+		delete(s.subs, sid)
+		s.broker.remove(sub)
+	}
+}
+
+// enqueue 把消息放入发送队列，队列满时按照 SlowConsumer 处理
+func (s *subscriber) enqueue(data []byte) {
+	select {
+	case <-s.closed:
+		return
+	case s.queue <- data:
+		return
+	default:
+	}
+	switch s.broker.option.SlowConsumer {
+	case DropOldest:
+		for {
+			select {
+			case <-s.queue:
+				s.slow()
+			default:
+			}
+			select {
+			case s.queue <- data:
+				return
+			default:
+			}
+		}
+	case Disconnect:
+		s.kick.Do(func() {
+			s.slow()
+			s.conn.CloseWithReason(ErrSlowConsumer)
+		})
+	default:
+		s.slow()
+	}
+}
+
+func (s *subscriber) slow() {
+	if fn := s.broker.option.OnSlowConsumer; fn != nil {
+		fn(s.conn)
+	}
+}
+
+// write 把发送队列中的消息合并写入连接
+func (s *subscriber) write() {
+	datas := make([][]byte, 0, maxBatchSize)
+	for {
+		select {
+		case data := <-s.queue:
+			datas = append(datas[:0], data)
+		case <-s.closed:
+			return
+		}
+	drain:
+		for len(datas) < maxBatchSize {
+			select {
+			case data := <-s.queue:
+				datas = append(datas, data)
+			default:
+				break drain
+			}
+		}
+		if err := s.conn.SendPkgBatch(datas, s.broker.option.PkgOption); err != nil {
+			s.conn.Close()
+			return
+		}
+	}
+}
+
+func (s *subscriber) close() {
+	for sid := range s.subs {
+		s.unsubscribe(sid)
+	}
+	close(s.closed)
+	s.conn.Close()
+}
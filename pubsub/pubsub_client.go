@@ -0,0 +1,235 @@
+package pubsub
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/motai3/xtcp"
+)
+
+// Client 订阅和发布消息，收发由 xtcp.Client 完成，收到的消息放入队列，由单独的分发协程按订阅 ID 交给对应的处理函数
+type Client struct {
+	client      *xtcp.Client
+	option      Option
+	mu          sync.Mutex
+	cond        *sync.Cond // 队列和下面的状态变化时通知等待的协程
+	seq         uint32
+	subs        map[uint32]*Subscription
+	queue       []*frame // 等待分发的消息
+	subscribing int      // 等待服务端确认的 Subscribe 数量
+	closed      bool
+	stopped     bool // 读取协程已经退出，分发完队列中的消息后分发协程退出
+}
+
+// Subscription 一个订阅，同一个主题匹配多个订阅时每个订阅都会收到一次
+type Subscription struct {
+	client  *Client
+	sid     uint32
+	pattern string
+	handler func(m *Message)
+}
+
+// Dial 连接 Broker，Option.TLSConfig 不为 nil 时使用 TLS
+func Dial(addr string, option ...Option) (*Client, error) {
+	o := getOption(option...)
+	var timeout []time.Duration
+	if o.Timeout > 0 {
+		timeout = []time.Duration{o.Timeout}
+	}
+	var (
+		conn *xtcp.Conn
+		err  error
+	)
+	if o.TLSConfig != nil {
+		conn, err = xtcp.NewConnTLS(addr, o.TLSConfig, timeout...)
+	} else {
+		conn, err = xtcp.NewConn(addr, timeout...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, o), nil
+}
+
+// NewClient 在已经建立的连接上创建客户端，Close 时会关闭该连接
+func NewClient(conn *xtcp.Conn, option ...Option) *Client {
+	c := &Client{
+		option: getOption(option...),
+		subs:   make(map[uint32]*Subscription),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	c.client = xtcp.NewClient(conn, xtcp.ClientOption{
+		PkgOption: c.option.PkgOption,
+		OnPush:    c.push,
+	})
+	go c.dispatch()
+	go func() {
+		<-c.client.Done()
+		c.mu.Lock()
+		c.stopped = true
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}()
+	return c
+}
+
+// Subscribe 订阅匹配 pattern 的主题，等待服务端确认后返回，之后发布的消息都会交给 handler
+// handler 在分发协程中依次调用，可以在 handler 中调用 Subscribe、Unsubscribe 和 Publish，但不能调用 Close
+// 等待分发的消息超过 Option.QueueSize 时读取协程会等待，服务端的发送队列被填满后按照服务端的 SlowConsumer 处理
+func (c *Client) Subscribe(pattern string, handler func(m *Message)) (*Subscription, error) {
+	if splitPattern(pattern) == nil {
+		return nil, ErrInvalidPattern
+	}
+	c.mu.Lock()
+	if c.closed || c.stopped {
+		c.mu.Unlock()
+		return nil, c.Err()
+	}
+	for {
+		c.seq++
+		if _, ok := c.subs[c.seq]; c.seq != 0 && !ok {
+			break
+		}
+	}
+	sub := &Subscription{
+		client:  c,
+		sid:     c.seq,
+		pattern: pattern,
+		handler: handler,
+	}
+	c.subs[sub.sid] = sub
+	// 等待确认期间读取协程不再因为队列已满而等待，在 handler 中调用 Subscribe 时分发协程无法消费队列
+	c.subscribing++
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	data, err := c.client.Call(packFrame(kindSubscribe, sub.sid, pattern, nil))
+	c.mu.Lock()
+	c.subscribing--
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	var f *frame
+	if err == nil {
+		f, err = unpackFrame(data)
+	}
+	if err == nil && f.kind == kindError {
+		err = errors.New(string(f.body))
+	}
+	if err != nil {
+		c.remove(sub.sid)
+		if e := c.Err(); e != nil {
+			return nil, e
+		}
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Publish 发布消息，不等待服务端转发
+func (c *Client) Publish(topic string, data []byte) error {
+	if splitTopic(topic) == nil {
+		return ErrInvalidTopic
+	}
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return c.client.Notify(packFrame(kindPublish, 0, topic, data))
+}
+
+// Close 关闭连接，所有订阅失效，队列中还没有分发的消息被丢弃
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.queue = nil
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return c.client.Close()
+}
+
+// Done 在读取协程退出时关闭，例如服务端因为 Disconnect 策略断开连接
+func (c *Client) Done() <-chan struct{} {
+	return c.client.Done()
+}
+
+// Err 返回读取协程退出的原因，主动关闭或者服务端关闭连接时为 ErrShutdown
+func (c *Client) Err() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	err := c.client.Err()
+	if closed || err == xtcp.ErrClientClosed || err == io.EOF {
+		return ErrShutdown
+	}
+	return err
+}
+
+func (c *Client) remove(sid uint32) {
+	c.mu.Lock()
+	delete(c.subs, sid)
+	c.mu.Unlock()
+}
+
+// push 在读取协程中把服务端推送的消息放入分发队列，队列已满时等待
+func (c *Client) push(data []byte) {
+	f, err := unpackFrame(data)
+	if err != nil || f.kind != kindMessage {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.queue) >= c.option.QueueSize && c.subscribing == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return
+	}
+	c.queue = append(c.queue, f)
+	c.cond.Broadcast()
+}
+
+// dispatch 依次把队列中的消息交给订阅的 handler
+func (c *Client) dispatch() {
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && !c.closed && !c.stopped {
+			c.cond.Wait()
+		}
+		if c.closed || len(c.queue) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		f := c.queue[0]
+		c.queue[0] = nil
+		c.queue = c.queue[1:]
+		c.cond.Broadcast()
+		// 取消订阅之前已经发出的消息直接丢弃
+		sub, ok := c.subs[f.sid]
+		c.mu.Unlock()
+		if ok {
+			sub.handler(&Message{Topic: f.topic, Data: f.body})
+		}
+	}
+}
+
+// Pattern 返回订阅模式
+func (s *Subscription) Pattern() string {
+	return s.pattern
+}
+
+// Unsubscribe 取消订阅，返回后 handler 不会再被调用（正在执行的除外）
+func (s *Subscription) Unsubscribe() error {
+	c := s.client
+	c.mu.Lock()
+	_, ok := c.subs[s.sid]
+	delete(c.subs, s.sid)
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return c.client.Notify(packFrame(kindUnsubscribe, s.sid, "", nil))
+}
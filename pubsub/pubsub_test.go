@@ -0,0 +1,294 @@
+package pubsub_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/motai3/xtcp"
+	"github.com/motai3/xtcp/container/xlist"
+	"github.com/motai3/xtcp/pubsub"
+	"github.com/stretchr/testify/assert"
+)
+
+var portList = xlist.New()
+
+func init() {
+	// 与其他包的测试并行运行，使用不同的端口
+	for i := 10200; i < 10300; i++ {
+		portList.PushBack(i)
+	}
+}
+
+// newTestBroker 启动 Broker，返回服务地址
+func newTestBroker(t *testing.T, broker *pubsub.Broker) string {
+	p := portList.PopFront().(int)
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), broker.ServeConn)
+	go server.Run()
+	t.Cleanup(func() {
+		server.Close()
+	})
+	time.Sleep(100 * time.Millisecond)
+	return fmt.Sprintf("127.0.0.1:%d", p)
+}
+
+func dial(t *testing.T, addr string, option ...pubsub.Option) *pubsub.Client {
+	client, err := pubsub.Dial(addr, option...)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+// collector 收集订阅收到的消息
+type collector struct {
+	mu     sync.Mutex
+	topics []string
+}
+
+func (c *collector) handle(m *pubsub.Message) {
+	c.mu.Lock()
+	c.topics = append(c.topics, m.Topic+":"+string(m.Data))
+	c.mu.Unlock()
+}
+
+func (c *collector) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.topics...)
+}
+
+func Test_Match(t *testing.T) {
+	cases := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.c", false},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", false},
+		{"a.*", "a", false},
+		{"*.b", "a.b", true},
+		{"a.>", "a.b", true},
+		{"a.>", "a.b.c", true},
+		{"a.>", "a", false},
+		{">", "a.b.c", true},
+		{"a.*.c", "a.b.c", true},
+		{"a.*.c", "a.b.d", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, pubsub.Match(c.pattern, c.topic), c.pattern+" "+c.topic)
+	}
+}
+
+func Test_PubSub(t *testing.T) {
+	broker := pubsub.NewBroker()
+	addr := newTestBroker(t, broker)
+	subscriber, publisher := dial(t, addr), dial(t, addr)
+
+	exact, single, multi := &collector{}, &collector{}, &collector{}
+	_, err := subscriber.Subscribe("order.created", exact.handle)
+	assert.NoError(t, err)
+	_, err = subscriber.Subscribe("order.*", single.handle)
+	assert.NoError(t, err)
+	sub, err := subscriber.Subscribe("order.>", multi.handle)
+	assert.NoError(t, err)
+	assert.Equal(t, "order.>", sub.Pattern())
+	assert.Equal(t, 3, broker.NumSubscriptions())
+
+	assert.NoError(t, publisher.Publish("order.created", []byte("1")))
+	assert.NoError(t, publisher.Publish("order.created.cn", []byte("2")))
+	assert.NoError(t, publisher.Publish("user.created", []byte("3")))
+	n, err := broker.Publish("order.paid", []byte("4"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"order.created:1"}, exact.get())
+	// 不同发布者之间的消息不保证顺序
+	assert.ElementsMatch(t, []string{"order.created:1", "order.paid:4"}, single.get())
+	assert.ElementsMatch(t, []string{"order.created:1", "order.created.cn:2", "order.paid:4"}, multi.get())
+
+	// 取消订阅后不再收到消息
+	assert.NoError(t, sub.Unsubscribe())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, broker.NumSubscriptions())
+	assert.NoError(t, publisher.Publish("order.created.cn", []byte("5")))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 3, len(multi.get()))
+
+	// 连接断开后取消所有订阅
+	subscriber.Close()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, broker.NumSubscriptions())
+}
+
+func Test_PubSub_SubscribeInHandler(t *testing.T) {
+	broker := pubsub.NewBroker()
+	addr := newTestBroker(t, broker)
+	// 队列很短，handler 阻塞时读取协程很快会等待队列
+	subscriber, publisher := dial(t, addr, pubsub.Option{QueueSize: 1}), dial(t, addr)
+
+	c := &collector{}
+	result := make(chan error, 1)
+	var once sync.Once
+	_, err := subscriber.Subscribe("a", func(m *pubsub.Message) {
+		once.Do(func() {
+			time.Sleep(50 * time.Millisecond)
+			_, err := subscriber.Subscribe("b", c.handle)
+			result <- err
+		})
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, publisher.Publish("a", []byte("1")))
+	}
+	select {
+	case err = <-result:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("subscribe in handler is blocked")
+	}
+	assert.NoError(t, publisher.Publish("b", []byte("2")))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"b:2"}, c.get())
+}
+
+func Test_PubSub_Invalid(t *testing.T) {
+	broker := pubsub.NewBroker()
+	client := dial(t, newTestBroker(t, broker))
+
+	_, err := client.Subscribe("a..b", func(m *pubsub.Message) {})
+	assert.Equal(t, pubsub.ErrInvalidPattern, err)
+	_, err = client.Subscribe("a.>.b", func(m *pubsub.Message) {})
+	assert.Equal(t, pubsub.ErrInvalidPattern, err)
+	assert.Equal(t, pubsub.ErrInvalidTopic, client.Publish("a.*", nil))
+	_, err = broker.Publish("", nil)
+	assert.Equal(t, pubsub.ErrInvalidTopic, err)
+}
+
+// slowSubscriber 订阅后阻塞在 handler 中，直到 release 被关闭
+func slowSubscriber(t *testing.T, addr string, release chan struct{}) (*pubsub.Client, *collector) {
+	client := dial(t, addr, pubsub.Option{QueueSize: 4})
+	c := &collector{}
+	_, err := client.Subscribe("slow", func(m *pubsub.Message) {
+		<-release
+		c.handle(m)
+	})
+	assert.NoError(t, err)
+	return client, c
+}
+
+func Test_PubSub_SlowConsumer(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		dropped int
+	)
+	broker := pubsub.NewBroker(pubsub.Option{
+		QueueSize: 4,
+		OnSlowConsumer: func(conn *xtcp.Conn) {
+			mu.Lock()
+			dropped++
+			mu.Unlock()
+		},
+	})
+	addr := newTestBroker(t, broker)
+	release := make(chan struct{})
+	_, c := slowSubscriber(t, addr, release)
+
+	// 订阅者不读取，发布者不会被阻塞，填满连接的缓冲后开始丢弃消息
+	data := make([]byte, 64*1024)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		broker.Publish("slow", data)
+	}
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	mu.Lock()
+	assert.Greater(t, dropped, 0)
+	mu.Unlock()
+
+	close(release)
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 1000, len(c.get())+dropped)
+	mu.Unlock()
+}
+
+func Test_PubSub_SlowConsumer_Disconnect(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		kicked []*xtcp.Conn
+	)
+	broker := pubsub.NewBroker(pubsub.Option{
+		QueueSize:    4,
+		SlowConsumer: pubsub.Disconnect,
+		OnSlowConsumer: func(conn *xtcp.Conn) {
+			mu.Lock()
+			kicked = append(kicked, conn)
+			mu.Unlock()
+		},
+	})
+	addr := newTestBroker(t, broker)
+	release := make(chan struct{})
+	client, _ := slowSubscriber(t, addr, release)
+
+	data := make([]byte, 64*1024)
+	for i := 0; i < 1000; i++ {
+		broker.Publish("slow", data)
+	}
+	mu.Lock()
+	assert.Equal(t, 1, len(kicked))
+	mu.Unlock()
+
+	close(release)
+	select {
+	case <-client.Done():
+		assert.Equal(t, pubsub.ErrShutdown, client.Err())
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer is not disconnected")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, broker.NumSubscriptions())
+}
+
+func Test_PubSub_SlowConsumer_DropOldest(t *testing.T) {
+	broker := pubsub.NewBroker(pubsub.Option{
+		QueueSize:    4,
+		SlowConsumer: pubsub.DropOldest,
+	})
+	addr := newTestBroker(t, broker)
+	release := make(chan struct{})
+	_, c := slowSubscriber(t, addr, release)
+
+	data := make([]byte, 64*1024)
+	for i := 0; i < 1000; i++ {
+		broker.Publish("slow", append(data[:len(data):len(data)], fmt.Sprint(i)...))
+	}
+	close(release)
+	time.Sleep(200 * time.Millisecond)
+	// 保留最新的消息
+	topics := c.get()
+	assert.Greater(t, len(topics), 0)
+	last := topics[len(topics)-1]
+	assert.Equal(t, "999", last[len(last)-3:])
+}
+
+func Test_PubSub_SharedCipher(t *testing.T) {
+	cipher, err := xtcp.NewPkgCipher(xtcp.PkgCipherServer, 1, make([]byte, 32))
+	assert.NoError(t, err)
+	broker := pubsub.NewBroker(pubsub.Option{PkgOption: xtcp.PkgOption{Frame: &xtcp.PkgFrame{}, Cipher: cipher}})
+
+	// Option 被所有连接共用，不能使用保存单个连接状态的 Cipher
+	client, peer := net.Pipe()
+	defer client.Close()
+	conn := xtcp.NewConnByNetConn(peer)
+	var reason error
+	conn.SetOnClose(func(c *xtcp.Conn, info xtcp.CloseInfo) {
+		reason = info.Reason
+	})
+	broker.ServeConn(conn)
+	assert.Equal(t, xtcp.ErrSharedCipher, reason)
+}
@@ -0,0 +1,142 @@
+// Package rpc 基于 xtcp pkg 协议的轻量 RPC，用法与 net/rpc 类似，支持 context、截止时间传递和流式响应
+//
+// 服务端注册对象后，符合以下签名的导出方法可以被客户端以 "服务名.方法名" 调用：
+//
+//	func (t *T) Method(args A, reply *R) error
+//	func (t *T) Method(ctx context.Context, args A, reply *R) error
+//	func (t *T) Method(ctx context.Context, args A, stream *rpc.Stream) error
+//
+// 客户端调用时 ctx 的截止时间会随请求发送到服务端，服务端方法收到的 ctx 在截止时间、客户端取消或者连接断开时结束
+package rpc
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/motai3/xtcp"
+)
+
+// 消息类型
+const (
+	kindRequest byte = iota + 1 // 请求，包含截止时间和方法名
+	kindReply                   // 普通调用的响应
+	kindStream                  // 流式响应中的一条数据
+	kindEnd                     // 流式响应结束
+	kindError                   // 调用失败，消息体为错误信息
+	kindCancel                  // 客户端取消调用
+)
+
+// 消息头为 ID(4 字节) + 类型(1 字节)，请求在消息头之后还有截止时间(8 字节，UnixNano，0 表示没有) + 方法名长度(2 字节) + 方法名
+// ID 与 xtcp.Client 的请求 ID 格式相同，客户端使用 xtcp.Client 收发消息，由它写入和去掉 ID
+const (
+	headerSize        = 5
+	requestHeaderSize = headerSize + 8 + 2
+)
+
+// Option 客户端和服务端的参数，两端需要使用相同的 PkgOption 和 Serializer
+type Option struct {
+	PkgOption  xtcp.PkgOption  // 零值时使用 4 字节长度头
+	Serializer xtcp.Serializer // 参数和返回值的序列化方式，默认 JSON
+	TLSConfig  *tls.Config     // Dial 使用 TLS 连接
+	Timeout    time.Duration   // Dial 的连接超时
+}
+
+// ServerError 服务端方法返回的错误
+type ServerError string
+
+// message 解析后的一条消息
+type message struct {
+	id       uint32
+	kind     byte
+	deadline time.Time
+	method   string
+	body     []byte
+}
+
+var (
+	ErrShutdown        = errors.New("rpc: connection is shut down")
+	errMessageTooShort = errors.New("rpc: message is too short")
+)
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+func getOption(option ...Option) Option {
+	o := Option{}
+	if len(option) > 0 {
+		o = option[0]
+	}
+	if o.PkgOption.HeaderSize == 0 && !o.PkgOption.Varint {
+		o.PkgOption.HeaderSize = 4
+	}
+	if o.Serializer == nil {
+		o.Serializer = xtcp.NewJSONSerializer()
+	}
+	return o
+}
+
+// appendHeader 编码消息头
+func appendHeader(dst []byte, id uint32, kind byte) []byte {
+	var header [headerSize]byte
+	binary.BigEndian.PutUint32(header[:], id)
+	header[4] = kind
+	return append(dst, header[:]...)
+}
+
+// packRequest 编码不含 ID 的请求，ID 由 xtcp.Client 写入
+func packRequest(deadline time.Time, method string, body []byte) []byte {
+	buffer := make([]byte, 0, requestHeaderSize-4+len(method)+len(body))
+	buffer = append(buffer, kindRequest)
+	var field [10]byte
+	if !deadline.IsZero() {
+		binary.BigEndian.PutUint64(field[:8], uint64(deadline.UnixNano()))
+	}
+	binary.BigEndian.PutUint16(field[8:], uint16(len(method)))
+	buffer = append(buffer, field[:]...)
+	buffer = append(buffer, method...)
+	return append(buffer, body...)
+}
+
+// packMessage 编码请求以外的消息
+func packMessage(id uint32, kind byte, body []byte) []byte {
+	buffer := make([]byte, 0, headerSize+len(body))
+	return append(appendHeader(buffer, id, kind), body...)
+}
+
+// unpackReply 解析 xtcp.Client 去掉 ID 之后的响应
+func unpackReply(data []byte) (*message, error) {
+	if len(data) < headerSize-4 {
+		return nil, errMessageTooShort
+	}
+	return &message{kind: data[0], body: data[1:]}, nil
+}
+
+func unpackMessage(data []byte) (*message, error) {
+	if len(data) < headerSize {
+		return nil, errMessageTooShort
+	}
+	m := &message{
+		id:   binary.BigEndian.Uint32(data),
+		kind: data[4],
+		body: data[headerSize:],
+	}
+	if m.kind != kindRequest {
+		return m, nil
+	}
+	if len(data) < requestHeaderSize {
+		return nil, errMessageTooShort
+	}
+	if deadline := binary.BigEndian.Uint64(data[headerSize:]); deadline != 0 {
+		m.deadline = time.Unix(0, int64(deadline))
+	}
+	size := int(binary.BigEndian.Uint16(data[headerSize+8:]))
+	if len(data) < requestHeaderSize+size {
+		return nil, errMessageTooShort
+	}
+	m.method = string(data[requestHeaderSize : requestHeaderSize+size])
+	m.body = data[requestHeaderSize+size:]
+	return m, nil
+}
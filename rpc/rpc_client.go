@@ -0,0 +1,230 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/motai3/xtcp"
+)
+
+// Client 在一个连接上并发调用服务端方法，请求 ID 的分配和响应的分发由 xtcp.Client 完成
+type Client struct {
+	client *xtcp.Client
+	option Option
+}
+
+// ClientStream 流式调用的响应
+type ClientStream struct {
+	client *Client
+	stream *xtcp.ClientStream
+	ctx    context.Context
+	err    error
+}
+
+const (
+	// 流式调用缓存的响应条数，缓存满时读取协程会等待，其他调用的响应也会被阻塞
+	streamBufferSize = 64
+	// 发送取消消息的超时，超时后关闭连接，避免半个包留在连接上
+	cancelTimeout = 3 * time.Second
+)
+
+var errPoolTLS = errors.New("rpc: pool connection does not support TLS")
+
+// Dial 连接 RPC 服务端，Option.TLSConfig 不为 nil 时使用 TLS
+func Dial(addr string, option ...Option) (*Client, error) {
+	o := getOption(option...)
+	var (
+		conn *xtcp.Conn
+		err  error
+	)
+	if o.TLSConfig != nil {
+		conn, err = xtcp.NewConnTLS(addr, o.TLSConfig, timeout(o)...)
+	} else {
+		conn, err = xtcp.NewConn(addr, timeout(o)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, o), nil
+}
+
+// DialPool 从 xtcp 连接池获取连接，Close 时把连接放回连接池，连接池不支持 TLS
+func DialPool(addr string, option ...Option) (*Client, error) {
+	o := getOption(option...)
+	if o.TLSConfig != nil {
+		return nil, errPoolTLS
+	}
+	conn, err := xtcp.NewPoolConn(addr, timeout(o)...)
+	if err != nil {
+		return nil, err
+	}
+	return NewPoolClient(conn, o), nil
+}
+
+// NewClient 在已经建立的连接上创建客户端，Close 时会关闭该连接
+func NewClient(conn *xtcp.Conn, option ...Option) *Client {
+	o := getOption(option...)
+	return &Client{
+		client: xtcp.NewClient(conn, xtcp.ClientOption{PkgOption: o.PkgOption}),
+		option: o,
+	}
+}
+
+// NewPoolClient 在 xtcp.NewPoolConn 返回的连接上创建客户端，Close 时如果连接仍然可用则放回连接池，否则关闭连接
+func NewPoolClient(conn *xtcp.PoolConn, option ...Option) *Client {
+	o := getOption(option...)
+	return &Client{
+		client: xtcp.NewPoolClient(conn, xtcp.ClientOption{PkgOption: o.PkgOption}),
+		option: o,
+	}
+}
+
+// Call 调用 serviceMethod（格式为 "服务名.方法名"），ctx 的截止时间会发送给服务端，ctx 结束时通知服务端取消
+func (c *Client) Call(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	stream, err := c.start(ctx, serviceMethod, args, 1)
+	if err != nil {
+		return err
+	}
+	data, err := stream.Recv(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.abort(stream)
+			return ctx.Err()
+		}
+		return c.Err()
+	}
+	stream.Close()
+	m, err := unpackReply(data)
+	if err != nil {
+		return err
+	}
+	switch m.kind {
+	case kindReply:
+		return c.option.Serializer.Unmarshal(m.body, reply)
+	case kindError:
+		return ServerError(m.body)
+	default:
+		// 服务端还会继续发送流式响应，通知服务端取消
+		c.abort(stream)
+		return fmt.Errorf(`rpc: %s is a stream method`, serviceMethod)
+	}
+}
+
+// Stream 调用服务端的流式方法，使用 ClientStream.Recv 逐条读取响应
+func (c *Client) Stream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error) {
+	stream, err := c.start(ctx, serviceMethod, args, streamBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientStream{
+		client: c,
+		stream: stream,
+		ctx:    ctx,
+	}, nil
+}
+
+// Close 关闭客户端，等待中的调用返回 ErrShutdown，NewPoolClient 创建的客户端把连接放回连接池
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+// Err 返回读取协程退出的原因，主动关闭或者服务端关闭连接时为 ErrShutdown
+func (c *Client) Err() error {
+	err := c.client.Err()
+	if err == xtcp.ErrClientClosed || err == io.EOF {
+		return ErrShutdown
+	}
+	return err
+}
+
+// start 发送请求
+func (c *Client) start(ctx context.Context, serviceMethod string, args interface{}, size int) (*xtcp.ClientStream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(serviceMethod) > 0xFFFF {
+		return nil, fmt.Errorf(`rpc: method name is too long`)
+	}
+	body, err := c.option.Serializer.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Err(); err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	stream, err := c.client.Stream(packRequest(deadline, serviceMethod, body), size)
+	if err != nil {
+		if e := c.Err(); e != nil {
+			return nil, e
+		}
+		return nil, err
+	}
+	return stream, nil
+}
+
+// abort 放弃等待响应，在单独的协程中通知服务端取消调用，调用方不会被阻塞在写入上
+// 取消消息在 cancelTimeout 内没有写完时关闭连接，半个包会破坏连接上的其他调用
+func (c *Client) abort(stream *xtcp.ClientStream) {
+	stream.Close()
+	conn, id := c.client.Conn(), stream.ID()
+	go func() {
+		if err := conn.SendPkgWithTimeout(packMessage(id, kindCancel, nil), cancelTimeout, c.option.PkgOption); err != nil {
+			conn.Close()
+		}
+	}()
+}
+
+func timeout(o Option) []time.Duration {
+	if o.Timeout > 0 {
+		return []time.Duration{o.Timeout}
+	}
+	return nil
+}
+
+// Recv 读取下一条响应到 v，流结束时返回 io.EOF，服务端方法返回的错误为 ServerError
+func (s *ClientStream) Recv(v interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	data, err := s.stream.Recv(s.ctx)
+	if err != nil {
+		if s.ctx.Err() != nil {
+			s.client.abort(s.stream)
+			s.err = s.ctx.Err()
+		} else {
+			s.err = s.client.Err()
+		}
+		return s.err
+	}
+	m, err := unpackReply(data)
+	if err != nil {
+		s.client.abort(s.stream)
+		s.err = err
+		return s.err
+	}
+	switch m.kind {
+	case kindStream:
+		return s.client.option.Serializer.Unmarshal(m.body, v)
+	case kindEnd:
+		s.err = io.EOF
+	case kindError:
+		s.err = ServerError(m.body)
+	default:
+		s.err = fmt.Errorf(`rpc: method is not a stream method`)
+	}
+	s.stream.Close()
+	return s.err
+}
+
+// Close 不再读取剩余的响应，通知服务端取消调用
+func (s *ClientStream) Close() error {
+	if s.err == nil {
+		s.client.abort(s.stream)
+		s.err = io.EOF
+	}
+	return nil
+}
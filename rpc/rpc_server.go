@@ -0,0 +1,265 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/motai3/xtcp"
+)
+
+// Server 保存注册的服务，ServeConn 可以直接作为 xtcp.Server 的 handler，TLS 等由 xtcp.Server 配置
+//
+//	server := rpc.NewServer()
+//	server.Register(new(Arith))
+//	xtcp.NewServer(":8999", server.ServeConn).Run()
+type Server struct {
+	option   Option
+	mu       sync.RWMutex
+	services map[string]*service
+}
+
+// Stream 流式响应，服务端方法通过 Send 逐条发送数据，方法返回后客户端收到结束标记
+type Stream struct {
+	conn *serverConn
+	id   uint32
+}
+
+type service struct {
+	name    string
+	rcvr    reflect.Value
+	methods map[string]*method
+}
+
+type method struct {
+	fn        reflect.Value
+	withCtx   bool
+	argType   reflect.Type
+	replyType reflect.Type // 流式方法为 nil
+}
+
+// serverConn 一个连接上正在处理的调用
+type serverConn struct {
+	server  *Server
+	conn    *xtcp.Conn
+	ctx     context.Context
+	mu      sync.Mutex
+	cancels map[uint32]context.CancelFunc
+}
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfStream  = reflect.TypeOf((*Stream)(nil))
+)
+
+func NewServer(option ...Option) *Server {
+	return &Server{
+		option:   getOption(option...),
+		services: make(map[string]*service),
+	}
+}
+
+// Register 使用接收者的类型名注册服务
+func (s *Server) Register(rcvr interface{}) error {
+	return s.RegisterName(reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name(), rcvr)
+}
+
+// RegisterName 使用指定的名称注册服务，没有符合签名的导出方法时返回错误
+func (s *Server) RegisterName(name string, rcvr interface{}) error {
+	if name == "" {
+		return errors.New("rpc: service name is empty")
+	}
+	svc := &service{
+		name:    name,
+		rcvr:    reflect.ValueOf(rcvr),
+		methods: make(map[string]*method),
+	}
+	typ := reflect.TypeOf(rcvr)
+	for i := 0; i < typ.NumMethod(); i++ {
+		if m := suitableMethod(typ.Method(i)); m != nil {
+			m.fn = svc.rcvr.Method(i)
+			svc.methods[typ.Method(i).Name] = m
+		}
+	}
+	if len(svc.methods) == 0 {
+		return fmt.Errorf(`rpc: type %s has no suitable methods`, typ)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.services[name]; ok {
+		return fmt.Errorf(`rpc: service %s is already defined`, name)
+	}
+	s.services[name] = svc
+	return nil
+}
+
+// ServeConn 处理一个连接上的请求，每个请求在单独的协程中执行，连接断开时取消所有未完成调用的 ctx
+// 所有连接共用 Option，PkgOption.Cipher 不为 nil 时以 xtcp.ErrSharedCipher 为原因关闭连接
+func (s *Server) ServeConn(conn *xtcp.Conn) {
+	if s.option.PkgOption.Cipher != nil {
+		conn.CloseWithReason(xtcp.ErrSharedCipher)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer conn.Close()
+	sc := &serverConn{
+		server:  s,
+		conn:    conn,
+		ctx:     ctx,
+		cancels: make(map[uint32]context.CancelFunc),
+	}
+	for {
+		data, err := conn.RecvPkg(s.option.PkgOption)
+		if err != nil {
+			return
+		}
+		m, err := unpackMessage(data)
+		if err != nil {
+			return
+		}
+		switch m.kind {
+		case kindRequest:
+			sc.start(m)
+		case kindCancel:
+			sc.cancel(m.id)
+		}
+	}
+}
+
+// suitableMethod 检查方法签名，不符合时返回 nil
+func suitableMethod(m reflect.Method) *method {
+	if m.PkgPath != "" {
+		return nil
+	}
+	typ := m.Type
+	in := 1
+	result := &method{}
+	if typ.NumIn() == 4 {
+		if typ.In(1) != typeOfContext {
+			return nil
+		}
+		result.withCtx = true
+		in++
+	} else if typ.NumIn() != 3 {
+		return nil
+	}
+	if typ.NumOut() != 1 || typ.Out(0) != typeOfError {
+		return nil
+	}
+	result.argType = typ.In(in)
+	replyType := typ.In(in + 1)
+	if replyType == typeOfStream {
+		if !result.withCtx {
+			return nil
+		}
+		return result
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return nil
+	}
+	result.replyType = replyType.Elem()
+	return result
+}
+
+func (s *Server) lookup(name string) (*method, error) {
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return nil, fmt.Errorf(`rpc: method %q is ill-formed`, name)
+	}
+	s.mu.RLock()
+	svc, ok := s.services[name[:dot]]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf(`rpc: can't find service %s`, name[:dot])
+	}
+	m, ok := svc.methods[name[dot+1:]]
+	if !ok {
+		return nil, fmt.Errorf(`rpc: can't find method %s`, name)
+	}
+	return m, nil
+}
+
+// start 在新的协程中执行调用
+func (sc *serverConn) start(m *message) {
+	ctx, cancel := sc.ctx, context.CancelFunc(nil)
+	if m.deadline.IsZero() {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithDeadline(ctx, m.deadline)
+	}
+	sc.mu.Lock()
+	sc.cancels[m.id] = cancel
+	sc.mu.Unlock()
+	go func() {
+		defer sc.cancel(m.id)
+		sc.call(ctx, m)
+	}()
+}
+
+func (sc *serverConn) cancel(id uint32) {
+	sc.mu.Lock()
+	cancel, ok := sc.cancels[id]
+	delete(sc.cancels, id)
+	sc.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+func (sc *serverConn) call(ctx context.Context, m *message) {
+	serializer := sc.server.option.Serializer
+	mtype, err := sc.server.lookup(m.method)
+	if err != nil {
+		sc.send(m.id, kindError, []byte(err.Error()))
+		return
+	}
+	arg := reflect.New(mtype.argType)
+	if err = serializer.Unmarshal(m.body, arg.Interface()); err != nil {
+		sc.send(m.id, kindError, []byte("rpc: decode args: "+err.Error()))
+		return
+	}
+	in := make([]reflect.Value, 0, 3)
+	if mtype.withCtx {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	in = append(in, arg.Elem())
+	var reply reflect.Value
+	if mtype.replyType == nil {
+		in = append(in, reflect.ValueOf(&Stream{conn: sc, id: m.id}))
+	} else {
+		reply = reflect.New(mtype.replyType)
+		in = append(in, reply)
+	}
+	if e := mtype.fn.Call(in)[0].Interface(); e != nil {
+		sc.send(m.id, kindError, []byte(e.(error).Error()))
+		return
+	}
+	if mtype.replyType == nil {
+		sc.send(m.id, kindEnd, nil)
+		return
+	}
+	body, err := serializer.Marshal(reply.Interface())
+	if err != nil {
+		sc.send(m.id, kindError, []byte("rpc: encode reply: "+err.Error()))
+		return
+	}
+	sc.send(m.id, kindReply, body)
+}
+
+func (sc *serverConn) send(id uint32, kind byte, body []byte) error {
+	return sc.conn.SendPkg(packMessage(id, kind, body), sc.server.option.PkgOption)
+}
+
+// Send 发送一条流式数据
+func (s *Stream) Send(v interface{}) error {
+	body, err := s.conn.server.option.Serializer.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.conn.send(s.id, kindStream, body)
+}
@@ -0,0 +1,233 @@
+package rpc_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/motai3/xtcp"
+	"github.com/motai3/xtcp/container/xlist"
+	"github.com/motai3/xtcp/rpc"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var portList = xlist.New()
+
+func init() {
+	// 与 xtcp 包的测试并行运行，使用不同的端口
+	for i := 10000; i < 10100; i++ {
+		portList.PushBack(i)
+	}
+}
+
+type Args struct {
+	A, B int
+}
+
+type Arith struct{}
+
+func (t *Arith) Add(args Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (t *Arith) Div(args Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+// Sleep 等待 ctx 结束，返回服务端看到的截止时间是否存在
+func (t *Arith) Sleep(ctx context.Context, d time.Duration, reply *bool) error {
+	_, *reply = ctx.Deadline()
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Arith) Count(ctx context.Context, n int, stream *rpc.Stream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Arith) unexported(args Args, reply *int) error {
+	return nil
+}
+
+// newTestServer 启动注册了 Arith 的服务端，返回服务地址
+func newTestServer(t *testing.T, option ...rpc.Option) string {
+	p := portList.PopFront().(int)
+	server := rpc.NewServer(option...)
+	assert.NoError(t, server.Register(new(Arith)))
+	assert.Error(t, server.Register(new(Arith)))
+	s := xtcp.NewServer(fmt.Sprintf(`:%d`, p), server.ServeConn)
+	go s.Run()
+	t.Cleanup(func() {
+		s.Close()
+	})
+	time.Sleep(100 * time.Millisecond)
+	return fmt.Sprintf("127.0.0.1:%d", p)
+}
+
+func newTestClient(t *testing.T, option ...rpc.Option) *rpc.Client {
+	client, err := rpc.Dial(newTestServer(t, option...), option...)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+func Test_RPC_Call(t *testing.T) {
+	for name, option := range map[string]rpc.Option{
+		"JSON": {},
+		"Gob":  {Serializer: xtcp.NewGobSerializer()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newTestClient(t, option)
+			ctx := context.Background()
+
+			var reply int
+			assert.NoError(t, client.Call(ctx, "Arith.Add", Args{1, 2}, &reply))
+			assert.Equal(t, 3, reply)
+
+			err := client.Call(ctx, "Arith.Div", Args{1, 0}, &reply)
+			assert.Equal(t, rpc.ServerError("divide by zero"), err)
+
+			err = client.Call(ctx, "Arith.Mul", Args{1, 2}, &reply)
+			assert.IsType(t, rpc.ServerError(""), err)
+			err = client.Call(ctx, "Arith.unexported", Args{1, 2}, &reply)
+			assert.IsType(t, rpc.ServerError(""), err)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					var reply int
+					assert.NoError(t, client.Call(ctx, "Arith.Add", Args{i, i}, &reply))
+					assert.Equal(t, 2*i, reply)
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func Test_RPC_Deadline(t *testing.T) {
+	client := newTestClient(t)
+
+	var hasDeadline bool
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, client.Call(ctx, "Arith.Sleep", time.Millisecond, &hasDeadline))
+	assert.True(t, hasDeadline)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.Call(ctx, "Arith.Sleep", time.Minute, &hasDeadline)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// 超时之后连接仍然可用
+	var reply int
+	assert.NoError(t, client.Call(context.Background(), "Arith.Add", Args{1, 1}, &reply))
+	assert.Equal(t, 2, reply)
+}
+
+func Test_RPC_Stream(t *testing.T) {
+	client := newTestClient(t)
+
+	stream, err := client.Stream(context.Background(), "Arith.Count", 100)
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		var v int
+		assert.NoError(t, stream.Recv(&v))
+		assert.Equal(t, i, v)
+	}
+	var v int
+	assert.Equal(t, io.EOF, stream.Recv(&v))
+
+	// 提前关闭流不会阻塞其他调用
+	stream, err = client.Stream(context.Background(), "Arith.Count", 1000)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Recv(&v))
+	stream.Close()
+	var reply int
+	assert.NoError(t, client.Call(context.Background(), "Arith.Add", Args{1, 1}, &reply))
+	assert.Equal(t, 2, reply)
+
+	client.Close()
+	assert.Equal(t, rpc.ErrShutdown, client.Call(context.Background(), "Arith.Add", Args{1, 1}, &reply))
+}
+
+func Test_RPC_Pool(t *testing.T) {
+	addr := newTestServer(t)
+	ctx := context.Background()
+
+	conn, err := xtcp.NewPoolConn(addr)
+	assert.NoError(t, err)
+	localAddr := conn.LocalAddr().String()
+	client := rpc.NewPoolClient(conn)
+	var reply int
+	assert.NoError(t, client.Call(ctx, "Arith.Add", Args{1, 2}, &reply))
+	assert.Equal(t, 3, reply)
+	assert.NoError(t, client.Close())
+
+	// Close 把连接放回连接池，DialPool 复用同一个连接
+	conn, err = xtcp.NewPoolConn(addr)
+	assert.NoError(t, err)
+	assert.Equal(t, localAddr, conn.LocalAddr().String())
+	conn.Close()
+	client, err = rpc.DialPool(addr)
+	assert.NoError(t, err)
+	assert.NoError(t, client.Call(ctx, "Arith.Add", Args{2, 2}, &reply))
+	assert.Equal(t, 4, reply)
+
+	// 放弃等待的响应不会被之后的客户端收到
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	var hasDeadline bool
+	assert.Equal(t, context.DeadlineExceeded, client.Call(timeout, "Arith.Sleep", time.Minute, &hasDeadline))
+	assert.NoError(t, client.Close())
+	client, err = rpc.DialPool(addr)
+	assert.NoError(t, err)
+	defer client.Close()
+	assert.NoError(t, client.Call(ctx, "Arith.Add", Args{3, 3}, &reply))
+	assert.Equal(t, 6, reply)
+
+	_, err = rpc.DialPool(addr, rpc.Option{TLSConfig: &tls.Config{}})
+	assert.Error(t, err)
+}
+
+func Test_RPC_SharedCipher(t *testing.T) {
+	cipher, err := xtcp.NewPkgCipher(xtcp.PkgCipherServer, 1, make([]byte, 32))
+	assert.NoError(t, err)
+	server := rpc.NewServer(rpc.Option{PkgOption: xtcp.PkgOption{Frame: &xtcp.PkgFrame{}, Cipher: cipher}})
+
+	// Option 被所有连接共用，不能使用保存单个连接状态的 Cipher
+	client, peer := net.Pipe()
+	defer client.Close()
+	conn := xtcp.NewConnByNetConn(peer)
+	var reason error
+	conn.SetOnClose(func(c *xtcp.Conn, info xtcp.CloseInfo) {
+		reason = info.Reason
+	})
+	server.ServeConn(conn)
+	assert.Equal(t, xtcp.ErrSharedCipher, reason)
+}
@@ -0,0 +1,155 @@
+package xtcp
+
+import (
+	"sync"
+)
+
+const (
+	bufferPoolMinShift = 9  // 最小的池化缓冲 512B
+	bufferPoolMaxShift = 22 // 最大的池化缓冲 4MB，更大的直接分配
+)
+
+// Buffer 从缓冲池获取的数据，使用完后调用 Release 归还，归还后不能再访问 Bytes 返回的数据
+// 缓冲池中只保存底层数组，Buffer 本身不会被复用，归还后 Buffer 不再持有底层数组
+type Buffer struct {
+	data   []byte
+	class  int     // 所属缓冲池，-1 表示不是池化的缓冲
+	pooled *[]byte // 从缓冲池取出的底层数组，归还后为 nil
+}
+
+// 按 2 的幂分级的缓冲池，保存 *[]byte
+var bufferPools [bufferPoolMaxShift - bufferPoolMinShift + 1]sync.Pool
+
+func init() {
+	for i := range bufferPools {
+		size := 1 << uint(i+bufferPoolMinShift)
+		bufferPools[i].New = func() interface{} {
+			data := make([]byte, size)
+			return &data
+		}
+	}
+}
+
+// getBuffer 获取长度为 length 的缓冲
+func getBuffer(length int) *Buffer {
+	class := bufferClass(length)
+	if class < 0 {
+		return &Buffer{data: make([]byte, length), class: -1}
+	}
+	pooled := bufferPools[class].Get().(*[]byte)
+	return &Buffer{data: (*pooled)[:length], class: class, pooled: pooled}
+}
+
+func bufferClass(length int) int {
+	for i := range bufferPools {
+		if length <= 1<<uint(i+bufferPoolMinShift) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+func (b *Buffer) Len() int {
+	return len(b.data)
+}
+
+// Release 把缓冲归还到缓冲池，同一个 Buffer 重复调用不会重复归还
+func (b *Buffer) Release() {
+	if b == nil || b.pooled == nil {
+		return
+	}
+	bufferPools[b.class].Put(b.pooled)
+	b.pooled = nil
+	b.data = nil
+}
+
+// RecvPkgBuffer 接收一个包，数据存放在池化的缓冲中，使用完后需要调用 Buffer.Release
+func (c *Conn) RecvPkgBuffer(option ...PkgOption) (*Buffer, error) {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
+		return nil, err
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	var buffers [4]*Buffer
+	used := buffers[:0]
+	_, data, err := c.readPkg(&pkgOption, func(length int) ([]byte, error) {
+		b := getBuffer(length)
+		used = append(used, b)
+		_, err := c.recvInto(b.data, pkgOption.Retry)
+		return b.data, err
+	})
+	var result *Buffer
+	if err == nil && len(data) > 0 && len(used) > 0 {
+		if last := used[len(used)-1]; &last.data[0] == &data[0] && len(last.data) == len(data) {
+			result = last
+			used = used[:len(used)-1]
+		}
+	}
+	for _, b := range used {
+		b.Release()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = &Buffer{data: data, class: -1}
+	}
+	return result, nil
+}
+
+// RecvPkgInto 接收一个包并存放到 buffer 中，返回的数据与 buffer 共享内存，
+// buffer 容量不足时会重新分配，需要使用返回值而不是 buffer
+func (c *Conn) RecvPkgInto(buffer []byte, option ...PkgOption) ([]byte, error) {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
+		return nil, err
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	arena := buffer[:0]
+	_, data, err := c.readPkg(&pkgOption, func(length int) ([]byte, error) {
+		if cap(arena)-len(arena) < length {
+			arena = make([]byte, 0, length)
+		}
+		data := arena[len(arena) : len(arena)+length]
+		arena = arena[:len(arena)+length]
+		_, err := c.recvInto(data, pkgOption.Retry)
+		return data, err
+	})
+	return data, err
+}
+
+func (c *PoolConn) RecvInto(buffer []byte, retry ...Retry) (int, error) {
+	n, err := c.Conn.RecvInto(buffer, retry...)
+	if err != nil {
+		c.status = connStatusError
+	} else {
+		c.status = connStatusActive
+	}
+	return n, err
+}
+
+func (c *PoolConn) RecvPkgInto(buffer []byte, option ...PkgOption) ([]byte, error) {
+	data, err := c.Conn.RecvPkgInto(buffer, option...)
+	if err != nil {
+		c.status = connStatusError
+	} else {
+		c.status = connStatusActive
+	}
+	return data, err
+}
+
+func (c *PoolConn) RecvPkgBuffer(option ...PkgOption) (*Buffer, error) {
+	b, err := c.Conn.RecvPkgBuffer(option...)
+	if err != nil {
+		c.status = connStatusError
+	} else {
+		c.status = connStatusActive
+	}
+	return b, err
+}
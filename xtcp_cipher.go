@@ -0,0 +1,164 @@
+package xtcp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// PkgFlagEncrypted 标志位，表示数据经过了 AEAD 加密
+const PkgFlagEncrypted byte = 0x02
+
+const (
+	PkgCipherClient = 0 // 客户端方向
+	PkgCipherServer = 1 // 服务端方向
+
+	pkgCipherSeqSize    = 8
+	pkgCipherPrefixSize = 1 + pkgCipherSeqSize // 密钥 ID + 序列号
+)
+
+var (
+	// ErrPkgDecrypt 数据认证失败，可能被篡改或者密钥不一致
+	ErrPkgDecrypt = errors.New("pkg decrypt failed")
+	// ErrPkgReplay 序列号不连续，可能是重放或者丢包
+	ErrPkgReplay = errors.New("pkg sequence mismatch")
+	// ErrPkgUnknownKey 对端使用了未知的密钥 ID
+	ErrPkgUnknownKey = errors.New("pkg unknown key id")
+	// ErrSharedCipher 多个连接共用的 PkgOption 设置了 Cipher，Cipher 保存单个连接的序号，不能共用
+	ErrSharedCipher = errors.New("shared pkg option can not use Cipher")
+)
+
+// PkgCipher 使用预共享密钥对包数据做 AEAD 加密，用于无法使用 TLS 的场景
+// 加密后的数据为 密钥 ID(1 字节) + 序列号(8 字节) + 密文，两个方向各自维护序列号，
+// 接收方只接受连续递增的序列号来防止重放。PkgCipher 带有状态，每个连接需要单独创建
+type PkgCipher struct {
+	mu      sync.Mutex
+	newAEAD func(key []byte) (cipher.AEAD, error)
+	keys    map[byte]cipher.AEAD
+	keyId   byte
+	role    byte
+	sendSeq uint64
+	recvSeq uint64
+}
+
+// NewPkgCipher 创建加密器，role 为 PkgCipherClient 或 PkgCipherServer，两端需要使用不同的 role
+// newAEAD 默认为 AES-GCM，ChaCha20-Poly1305 可以传入 golang.org/x/crypto/chacha20poly1305.New
+func NewPkgCipher(role int, keyId byte, key []byte, newAEAD ...func(key []byte) (cipher.AEAD, error)) (*PkgCipher, error) {
+	if role != PkgCipherClient && role != PkgCipherServer {
+		return nil, fmt.Errorf(`invalid cipher role %d`, role)
+	}
+	p := &PkgCipher{
+		newAEAD: newAESGCM,
+		keys:    make(map[byte]cipher.AEAD),
+		role:    byte(role),
+	}
+	if len(newAEAD) > 0 && newAEAD[0] != nil {
+		p.newAEAD = newAEAD[0]
+	}
+	if err := p.AddKey(keyId, key); err != nil {
+		return nil, err
+	}
+	p.keyId = keyId
+	return p, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// AddKey 添加密钥，添加后可以解密对端使用该密钥加密的数据
+func (p *PkgCipher) AddKey(keyId byte, key []byte) error {
+	aead, err := p.newAEAD(key)
+	if err != nil {
+		return err
+	}
+	if aead.NonceSize() < pkgCipherSeqSize+1 {
+		return errors.New("AEAD nonce size is too small")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[keyId] = aead
+	return nil
+}
+
+// UseKey 切换发送使用的密钥，用于密钥轮换
+func (p *PkgCipher) UseKey(keyId byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.keys[keyId]; !ok {
+		return ErrPkgUnknownKey
+	}
+	p.keyId = keyId
+	return nil
+}
+
+// RemoveKey 删除不再使用的密钥，不能删除当前发送使用的密钥
+func (p *PkgCipher) RemoveKey(keyId byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if keyId == p.keyId {
+		return errors.New("can not remove the key in use")
+	}
+	delete(p.keys, keyId)
+	return nil
+}
+
+// overhead 加密增加的字节数
+func (p *PkgCipher) overhead() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return pkgCipherPrefixSize + p.keys[p.keyId].Overhead()
+}
+
+// nonce 由方向和序列号组成，保证同一个密钥下两个方向的 nonce 不会重复
+func (p *PkgCipher) nonce(aead cipher.AEAD, role byte, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	nonce[0] = role
+	binary.BigEndian.PutUint64(nonce[len(nonce)-pkgCipherSeqSize:], seq)
+	return nonce
+}
+
+func (p *PkgCipher) seal(flags byte, data []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sendSeq == ^uint64(0) {
+		return nil, errors.New("pkg sequence exhausted")
+	}
+	p.sendSeq++
+	aead := p.keys[p.keyId]
+	buffer := make([]byte, pkgCipherPrefixSize, pkgCipherPrefixSize+len(data)+aead.Overhead())
+	buffer[0] = p.keyId
+	binary.BigEndian.PutUint64(buffer[1:], p.sendSeq)
+	additional := append([]byte{flags}, buffer...)
+	return aead.Seal(buffer, p.nonce(aead, p.role, p.sendSeq), data, additional), nil
+}
+
+func (p *PkgCipher) open(flags byte, data []byte) ([]byte, error) {
+	if len(data) < pkgCipherPrefixSize {
+		return nil, ErrPkgDecrypt
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	aead, ok := p.keys[data[0]]
+	if !ok {
+		return nil, fmt.Errorf(`%w: %d`, ErrPkgUnknownKey, data[0])
+	}
+	seq := binary.BigEndian.Uint64(data[1:])
+	if seq != p.recvSeq+1 {
+		return nil, fmt.Errorf(`%w: got %d, want %d`, ErrPkgReplay, seq, p.recvSeq+1)
+	}
+	additional := append([]byte{flags}, data[:pkgCipherPrefixSize]...)
+	result, err := aead.Open(nil, p.nonce(aead, 1-p.role, seq), data[pkgCipherPrefixSize:], additional)
+	if err != nil {
+		return nil, ErrPkgDecrypt
+	}
+	p.recvSeq = seq
+	return result, nil
+}
@@ -0,0 +1,132 @@
+package xtcp_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/motai3/xtcp"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	cipherKey1 = bytes.Repeat([]byte{1}, 32)
+	cipherKey2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func Test_Cipher_Basic(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		cipher, _ := xtcp.NewPkgCipher(xtcp.PkgCipherServer, 1, cipherKey1)
+		cipher.AddKey(2, cipherKey2)
+		option := xtcp.PkgOption{Frame: &xtcp.PkgFrame{}, Cipher: cipher, Compressor: xtcp.NewFlateCompressor()}
+		for {
+			data, err := conn.RecvPkg(option)
+			if err != nil {
+				break
+			}
+			conn.SendPkg(data, option)
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+	cipher, err := xtcp.NewPkgCipher(xtcp.PkgCipherClient, 1, cipherKey1)
+	assert.NoError(t, err)
+	option := xtcp.PkgOption{
+		Frame:      &xtcp.PkgFrame{},
+		Cipher:     cipher,
+		Compressor: xtcp.NewFlateCompressor(),
+	}
+
+	for i := 0; i < 10; i++ {
+		data := bytes.Repeat([]byte("secret"), i*100)
+		result, err := conn.SendRecvPkg(data, option)
+		assert.NoError(t, err)
+		assert.Equal(t, string(data), string(result))
+	}
+
+	assert.NoError(t, cipher.AddKey(2, cipherKey2))
+	assert.NoError(t, cipher.UseKey(2))
+	assert.Error(t, cipher.RemoveKey(2))
+	result, err := conn.SendRecvPkg([]byte("rotated"), option)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("rotated"), result)
+}
+
+func Test_Cipher_Tamper(t *testing.T) {
+	newCodec := func(role int) xtcp.Codec {
+		cipher, err := xtcp.NewPkgCipher(role, 1, cipherKey1)
+		assert.NoError(t, err)
+		return newPkgCodec(t, xtcp.PkgOption{Frame: &xtcp.PkgFrame{}, Cipher: cipher})
+	}
+	sender := newCodec(xtcp.PkgCipherClient)
+	frame, err := sender.Encode([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(frame), "hello")
+
+	t.Run("Replay", func(t *testing.T) {
+		receiver := newCodec(xtcp.PkgCipherServer)
+		data, err := receiver.Decode(bufio.NewReader(bytes.NewReader(frame)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("hello"), data)
+		_, err = receiver.Decode(bufio.NewReader(bytes.NewReader(frame)))
+		assert.True(t, errors.Is(err, xtcp.ErrPkgReplay))
+	})
+
+	t.Run("Modified", func(t *testing.T) {
+		bad := append([]byte{}, frame...)
+		bad[len(bad)-1] ^= 1
+		_, err := newCodec(xtcp.PkgCipherServer).Decode(bufio.NewReader(bytes.NewReader(bad)))
+		assert.Equal(t, xtcp.ErrPkgDecrypt, err)
+	})
+
+	t.Run("Reflected", func(t *testing.T) {
+		_, err := newCodec(xtcp.PkgCipherClient).Decode(bufio.NewReader(bytes.NewReader(frame)))
+		assert.Equal(t, xtcp.ErrPkgDecrypt, err)
+	})
+}
+
+func Test_Cipher_Batch(t *testing.T) {
+	client, server := net.Pipe()
+	conn := xtcp.NewConnByNetConn(client)
+	defer conn.Close()
+	peer := xtcp.NewConnByNetConn(server)
+	defer peer.Close()
+	sender, _ := xtcp.NewPkgCipher(xtcp.PkgCipherClient, 1, cipherKey1)
+	receiver, _ := xtcp.NewPkgCipher(xtcp.PkgCipherServer, 1, cipherKey1)
+	option := xtcp.PkgOption{Frame: &xtcp.PkgFrame{}, Cipher: sender}
+	peerOption := xtcp.PkgOption{Frame: &xtcp.PkgFrame{}, Cipher: receiver}
+
+	// 最后一个包加密后超过长度字段的上限，整批返回错误，前面的包也不会加密
+	err := conn.SendPkgBatch([][]byte{[]byte("a"), make([]byte, 0xFFFF)}, option)
+	assert.Error(t, err)
+
+	// 序列号没有推进，对端可以继续解密
+	go conn.SendPkgBatch([][]byte{[]byte("b"), []byte("c")}, option)
+	for _, want := range []string{"b", "c"} {
+		data, err := peer.RecvPkg(peerOption)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+}
+
+func Test_Cipher_Shared(t *testing.T) {
+	cipher, err := xtcp.NewPkgCipher(xtcp.PkgCipherServer, 1, cipherKey1)
+	assert.NoError(t, err)
+	option := xtcp.PkgOption{Frame: &xtcp.PkgFrame{}, Cipher: cipher}
+
+	// Server 的 Codec 被所有连接共用，不能使用保存单个连接状态的 Cipher
+	server := xtcp.NewServer(":0", func(conn *xtcp.Conn) {})
+	assert.Equal(t, xtcp.ErrSharedCipher, server.SetCodec(newPkgCodec(t, option)))
+	assert.NoError(t, server.SetCodec(newPkgCodec(t, xtcp.PkgOption{Frame: &xtcp.PkgFrame{}})))
+}
@@ -0,0 +1,346 @@
+package xtcp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// callIdSize 请求 ID 的字节数，ID 使用 PackCmd 的 4 字节命令字格式写在消息体前面
+const callIdSize = 4
+
+// ClientOption 多路复用客户端的参数
+type ClientOption struct {
+	PkgOption PkgOption
+	Timeout   time.Duration // Call 的默认超时，0 表示不超时
+	// OnPush 收到服务端推送（ID 为 0 的消息）时在读取协程中调用，为 nil 时丢弃推送
+	OnPush func(data []byte)
+}
+
+// Client 在一个连接上并发发送多个请求，每个请求带有 ID，由一个读取协程按 ID 把响应交给等待的调用方
+// 消息格式为 ID(4 字节大端) + 消息体，服务端使用 RecvCall/SendReply 处理请求，ID 0 保留给服务端推送
+// 创建 Client 之后不要再直接读取连接
+type Client struct {
+	conn     *Conn
+	pool     *PoolConn // NewPoolClient 创建时不为 nil，Close 时尝试放回连接池
+	option   ClientOption
+	mu       sync.Mutex
+	seq      uint32
+	pending  map[uint32]*pendingCall
+	err      error // 读取协程退出的原因
+	closed   bool
+	reusable bool // 读取协程在两条消息之间被 Close 打断，连接可以放回连接池
+	done     chan struct{}
+}
+
+// pendingCall 等待响应的请求
+type pendingCall struct {
+	ch     chan []byte
+	quit   chan struct{} // 调用方不再接收时关闭，避免读取协程阻塞在已满的 ch 上
+	stream bool          // 可以接收多次响应，直到调用方关闭
+}
+
+// ClientStream 可以接收服务端对同一个请求 ID 的多次回复
+type ClientStream struct {
+	client *Client
+	id     uint32
+	call   *pendingCall
+}
+
+var (
+	ErrClientClosed = errors.New("client is closed")
+	// ErrCallIdZero 请求 ID 0 保留给服务端推送
+	ErrCallIdZero = errors.New("call id 0 is reserved for push")
+)
+
+// NewClient 在 conn 上创建多路复用客户端并启动读取协程
+func NewClient(conn *Conn, option ...ClientOption) *Client {
+	c := &Client{
+		conn:    conn,
+		pending: make(map[uint32]*pendingCall),
+		done:    make(chan struct{}),
+	}
+	if len(option) > 0 {
+		c.option = option[0]
+	}
+	go c.read()
+	return c
+}
+
+// NewPoolClient 在连接池的连接上创建客户端，Close 时如果读取协程停在两条消息之间，把连接放回连接池，否则关闭连接
+// 请求 ID 在同一个连接的多个 Client 之间连续分配，之前的 Client 放弃等待的响应不会被交给新的调用
+// 放回连接池的连接上可能还有未读取的响应和推送，只应该再交给 NewPoolClient 使用
+func NewPoolClient(conn *PoolConn, option ...ClientOption) *Client {
+	c := &Client{
+		conn:    conn.Conn,
+		pool:    conn,
+		seq:     conn.callSeq,
+		pending: make(map[uint32]*pendingCall),
+		done:    make(chan struct{}),
+	}
+	if len(option) > 0 {
+		c.option = option[0]
+	}
+	go c.read()
+	return c
+}
+
+// Conn 返回底层连接
+func (c *Client) Conn() *Conn {
+	return c.conn
+}
+
+// Call 发送请求并等待对应的响应，timeout 为空时使用 ClientOption.Timeout
+func (c *Client) Call(data []byte, timeout ...time.Duration) ([]byte, error) {
+	d := c.option.Timeout
+	if len(timeout) > 0 {
+		d = timeout[0]
+	}
+	if d <= 0 {
+		return c.CallCtx(context.Background(), data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return c.CallCtx(ctx, data)
+}
+
+// CallCtx 发送请求并等待对应的响应，ctx 结束时返回 ctx.Err()，之后到达的响应会被丢弃
+func (c *Client) CallCtx(ctx context.Context, data []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	id, call, err := c.send(data, 1, false)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case result, ok := <-call.ch:
+		if !ok {
+			return nil, c.Err()
+		}
+		return result, nil
+	case <-ctx.Done():
+		c.unregister(id)
+		return nil, ctx.Err()
+	}
+}
+
+// Stream 发送请求，返回的 ClientStream 可以接收服务端使用 SendReply 对同一个 ID 的多次回复，size 为缓存的回复数量
+// 缓存满时读取协程会等待，其他请求的响应也会被阻塞，不再接收时需要调用 ClientStream.Close
+func (c *Client) Stream(data []byte, size int) (*ClientStream, error) {
+	id, call, err := c.send(data, size, true)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientStream{
+		client: c,
+		id:     id,
+		call:   call,
+	}, nil
+}
+
+// Notify 发送不需要响应的消息，ID 为 0
+func (c *Client) Notify(data []byte) error {
+	buffer, err := PackCmd(0, data, callIdSize)
+	if err != nil {
+		return err
+	}
+	return c.conn.SendPkg(buffer, c.option.PkgOption)
+}
+
+// Close 关闭连接，等待中的调用返回 ErrClientClosed，NewPoolClient 创建的客户端尝试把连接放回连接池
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.done
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+	if c.pool == nil {
+		err := c.conn.Close()
+		<-c.done
+		return err
+	}
+	// 打断读取协程，读取协程停在两条消息之间时连接仍然可以继续使用
+	c.conn.Conn.SetReadDeadline(aLongTimeAgo)
+	<-c.done
+	if !c.reusable {
+		c.pool.status = connStatusError
+		return c.pool.Close()
+	}
+	if err := c.conn.Conn.SetReadDeadline(c.conn.getReceiveDeadline()); err != nil {
+		c.pool.status = connStatusError
+		return c.pool.Close()
+	}
+	c.mu.Lock()
+	c.pool.callSeq = c.seq
+	c.mu.Unlock()
+	c.pool.status = connStatusActive
+	return c.pool.Close()
+}
+
+// Done 在读取协程退出时关闭，之后的调用都会返回 Err
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 返回读取协程退出的原因，主动关闭时为 ErrClientClosed
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// send 注册并发送请求
+func (c *Client) send(data []byte, size int, stream bool) (uint32, *pendingCall, error) {
+	id, call, err := c.register(size, stream)
+	if err != nil {
+		return 0, nil, err
+	}
+	buffer, err := PackCmd(id, data, callIdSize)
+	if err == nil {
+		// ctx 结束时打断写入会留下半个包，破坏其他调用共享的连接，这里不使用 SendPkgCtx，ctx 只控制等待响应的时间
+		err = c.conn.SendPkg(buffer, c.option.PkgOption)
+	}
+	if err != nil {
+		c.unregister(id)
+		return 0, nil, err
+	}
+	return id, call, nil
+}
+
+func (c *Client) register(size int, stream bool) (uint32, *pendingCall, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	for {
+		c.seq++
+		if _, ok := c.pending[c.seq]; c.seq != 0 && !ok {
+			break
+		}
+	}
+	call := &pendingCall{
+		ch:     make(chan []byte, size),
+		quit:   make(chan struct{}),
+		stream: stream,
+	}
+	c.pending[c.seq] = call
+	return c.seq, call, nil
+}
+
+func (c *Client) unregister(id uint32) {
+	c.mu.Lock()
+	if call, ok := c.pending[id]; ok {
+		close(call.quit)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+}
+
+// read 循环读取响应并按 ID 分发，连接出错时结束所有等待中的调用
+func (c *Client) read() {
+	defer close(c.done)
+	for {
+		buffered, bytesRead := c.conn.reader.Buffered(), c.conn.BytesRead()
+		data, err := c.conn.RecvPkg(c.option.PkgOption)
+		var id uint32
+		if err == nil {
+			id, data, err = UnpackCmd(data, callIdSize)
+		}
+		if err != nil {
+			// 没有读取到下一条消息的任何数据时被 Close 打断，连接可以放回连接池
+			c.reusable = c.pool != nil && isTimeout(err) && buffered == 0 && c.conn.BytesRead() == bytesRead
+			c.fail(err)
+			return
+		}
+		if id == 0 {
+			if c.option.OnPush != nil {
+				c.option.OnPush(data)
+			}
+			continue
+		}
+		c.mu.Lock()
+		call, ok := c.pending[id]
+		if ok && !call.stream {
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		if ok {
+			select {
+			case call.ch <- data:
+			case <-call.quit:
+			}
+		}
+	}
+}
+
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		err = ErrClientClosed
+	}
+	c.err = err
+	for id, call := range c.pending {
+		close(call.ch)
+		delete(c.pending, id)
+	}
+}
+
+// ID 返回请求 ID
+func (s *ClientStream) ID() uint32 {
+	return s.id
+}
+
+// Recv 接收下一条回复，ctx 结束时返回 ctx.Err()，连接断开时返回 Client.Err()
+func (s *ClientStream) Recv(ctx context.Context) ([]byte, error) {
+	select {
+	case data, ok := <-s.call.ch:
+		if !ok {
+			return nil, s.client.Err()
+		}
+		return data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close 不再接收回复，之后到达的回复会被丢弃
+func (s *ClientStream) Close() {
+	s.client.unregister(s.id)
+}
+
+// RecvCall 接收 Client 发送的请求，返回请求 ID，ID 为 0 表示不需要响应的 Notify
+func (c *Conn) RecvCall(option ...PkgOption) (uint32, []byte, error) {
+	data, err := c.RecvPkg(option...)
+	if err != nil {
+		return 0, nil, err
+	}
+	return UnpackCmd(data, callIdSize)
+}
+
+// SendReply 回复 ID 为 id 的请求
+func (c *Conn) SendReply(id uint32, data []byte, option ...PkgOption) error {
+	if id == 0 {
+		return ErrCallIdZero
+	}
+	buffer, err := PackCmd(id, data, callIdSize)
+	if err != nil {
+		return err
+	}
+	return c.SendPkg(buffer, option...)
+}
+
+// SendPush 向 Client 推送消息，由 ClientOption.OnPush 处理
+func (c *Conn) SendPush(data []byte, option ...PkgOption) error {
+	buffer, err := PackCmd(0, data, callIdSize)
+	if err != nil {
+		return err
+	}
+	return c.SendPkg(buffer, option...)
+}
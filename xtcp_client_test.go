@@ -0,0 +1,195 @@
+package xtcp_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/motai3/xtcp"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Client_Call(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			id, data, err := conn.RecvCall()
+			if err != nil {
+				break
+			}
+			if id == 0 {
+				conn.SendPush(append([]byte("push:"), data...))
+				continue
+			}
+			if string(data) == "slow" {
+				continue
+			}
+			// 倒序延迟回复，响应到达的顺序与请求不同
+			n, _ := strconv.Atoi(string(data))
+			go func() {
+				time.Sleep(time.Duration(50-n) * time.Millisecond)
+				conn.SendReply(id, append([]byte(">"), data...))
+			}()
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	pushes := make(chan string, 1)
+	client := xtcp.NewClient(conn, xtcp.ClientOption{
+		OnPush: func(data []byte) {
+			pushes <- string(data)
+		},
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				data, err := client.Call([]byte(strconv.Itoa(i)), time.Second)
+				assert.NoError(t, err)
+				assert.Equal(t, ">"+strconv.Itoa(i), string(data))
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	t.Run("Push", func(t *testing.T) {
+		assert.NoError(t, client.Notify([]byte("hi")))
+		select {
+		case data := <-pushes:
+			assert.Equal(t, "push:hi", data)
+		case <-time.After(time.Second):
+			t.Fatal("push is not received")
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		_, err := client.Call([]byte("slow"), 50*time.Millisecond)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		data, err := client.Call([]byte("1"), time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, ">1", string(data))
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		result := make(chan error)
+		go func() {
+			_, err := client.Call([]byte("slow"))
+			result <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		client.Close()
+		assert.Equal(t, xtcp.ErrClientClosed, <-result)
+		_, err := client.Call([]byte("1"))
+		assert.Equal(t, xtcp.ErrClientClosed, err)
+		assert.Equal(t, xtcp.ErrCallIdZero, conn.SendReply(0, nil))
+	})
+}
+
+func Test_Client_Stream(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			id, data, err := conn.RecvCall()
+			if err != nil {
+				break
+			}
+			// 对同一个 ID 回复 n 次
+			n, _ := strconv.Atoi(string(data))
+			for i := 0; i < n; i++ {
+				conn.SendReply(id, []byte(strconv.Itoa(i)))
+			}
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	client := xtcp.NewClient(conn)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	stream, err := client.Stream([]byte("3"), 1)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		data, err := stream.Recv(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(data))
+	}
+	stream.Close()
+
+	// 没有接收的回复填满缓存后，关闭的 stream 不会阻塞读取协程
+	stream, err = client.Stream([]byte("10"), 1)
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	stream.Close()
+	data, err := client.Call([]byte("1"), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", string(data))
+}
+
+func Test_Client_Pool(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			id, data, err := conn.RecvCall()
+			if err != nil {
+				break
+			}
+			if string(data) == "half" {
+				// 只发送半个包
+				conn.Send([]byte{0, 10, 0})
+				continue
+			}
+			conn.SendReply(id, data)
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", p)
+	conn, err := xtcp.NewPoolConn(addr)
+	assert.NoError(t, err)
+	localAddr := conn.LocalAddr().String()
+	client := xtcp.NewPoolClient(conn)
+	data, err := client.Call([]byte("1"), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(data))
+	assert.NoError(t, client.Close())
+
+	// 连接放回连接池后被下一个客户端复用
+	conn, err = xtcp.NewPoolConn(addr)
+	assert.NoError(t, err)
+	assert.Equal(t, localAddr, conn.LocalAddr().String())
+	client = xtcp.NewPoolClient(conn)
+	data, err = client.Call([]byte("2"), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(data))
+
+	// 读取到一半的连接不会放回连接池
+	_, err = client.Call([]byte("half"), 50*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.NoError(t, client.Close())
+	conn, err = xtcp.NewPoolConn(addr)
+	assert.NoError(t, err)
+	assert.NotEqual(t, localAddr, conn.LocalAddr().String())
+	conn.Close()
+}
@@ -0,0 +1,270 @@
+package xtcp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Codec 定义消息的分帧方式，Encode 生成一帧完整的数据，Decode 从连接的读缓冲中读取一帧
+// 同一个 Codec 可能被多个连接同时使用，实现需要是并发安全的
+type Codec interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(reader *bufio.Reader) ([]byte, error)
+}
+
+// pkgCodec 长度前缀分帧，与 SendPkg/RecvPkg 的格式一致
+type pkgCodec struct {
+	option *PkgOption
+}
+
+// delimiterCodec 分隔符分帧，Decode 返回的数据不包含分隔符
+type delimiterCodec struct {
+	delimiter []byte
+	maxLength int
+}
+
+// fixedLengthCodec 定长分帧
+type fixedLengthCodec struct {
+	length int
+}
+
+// lineCodec 按行分帧，兼容 \n 和 \r\n
+type lineCodec struct {
+	maxLength int
+}
+
+// 未设置 Codec 时使用的默认分帧方式，默认参数不会返回错误
+var defaultCodec, _ = NewPkgCodec()
+
+// NewPkgCodec 创建长度前缀分帧，option 不合法时返回错误
+func NewPkgCodec(option ...PkgOption) (Codec, error) {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
+		return nil, err
+	}
+	return &pkgCodec{option: &pkgOption}, nil
+}
+
+// NewDelimiterCodec 创建分隔符分帧，maxLength 为一帧的最大长度（包含分隔符），默认 64KB，delimiter 为空时返回错误
+func NewDelimiterCodec(delimiter []byte, maxLength ...int) (Codec, error) {
+	if len(delimiter) == 0 {
+		return nil, errors.New("delimiter is empty")
+	}
+	return &delimiterCodec{
+		delimiter: append([]byte(nil), delimiter...),
+		maxLength: getMaxLineLength(maxLength...),
+	}, nil
+}
+
+// NewFixedLengthCodec 创建定长分帧，length 不是正数时返回错误
+func NewFixedLengthCodec(length int) (Codec, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf(`invalid fixed length %d`, length)
+	}
+	return &fixedLengthCodec{length: length}, nil
+}
+
+// NewLineCodec 创建按行分帧，maxLength 为一行的最大长度（包含换行符），默认 64KB
+func NewLineCodec(maxLength ...int) Codec {
+	return lineCodec{maxLength: getMaxLineLength(maxLength...)}
+}
+
+func (p *pkgCodec) Encode(data []byte) ([]byte, error) {
+	return p.option.pack(data)
+}
+
+// Decode 读取一个包并跳过心跳包，直接调用时不会回复 ping，通过 Conn.RecvMsg 读取时与 RecvPkg 一样自动回复 pong
+func (p *pkgCodec) Decode(reader *bufio.Reader) ([]byte, error) {
+	read := func(length int) ([]byte, error) {
+		return readFull(reader, length)
+	}
+	if p.option.Frame == nil {
+		return p.option.unpack(read)
+	}
+	for {
+		flags, data, err := p.option.unpackFrame(read)
+		if err != nil || !isControl(flags) {
+			return data, err
+		}
+	}
+}
+
+func (d *delimiterCodec) Encode(data []byte) ([]byte, error) {
+	if bytes.Contains(data, d.delimiter) {
+		return nil, errors.New("data contains delimiter")
+	}
+	buffer := make([]byte, 0, len(data)+len(d.delimiter))
+	return append(append(buffer, data...), d.delimiter...), nil
+}
+
+func (d *delimiterCodec) Decode(reader *bufio.Reader) ([]byte, error) {
+	data, err := readUntil(reader, d.delimiter, d.maxLength)
+	if err != nil {
+		return nil, unexpectedEOF(data, err)
+	}
+	return data[:len(data)-len(d.delimiter)], nil
+}
+
+func (f *fixedLengthCodec) Encode(data []byte) ([]byte, error) {
+	if len(data) != f.length {
+		return nil, fmt.Errorf(`data size %d does not match fixed length %d`, len(data), f.length)
+	}
+	return data, nil
+}
+
+func (f *fixedLengthCodec) Decode(reader *bufio.Reader) ([]byte, error) {
+	return readFull(reader, f.length)
+}
+
+func (l lineCodec) Encode(data []byte) ([]byte, error) {
+	if bytes.IndexByte(data, '\n') >= 0 {
+		return nil, errors.New("data contains line break")
+	}
+	buffer := make([]byte, 0, len(data)+1)
+	return append(append(buffer, data...), '\n'), nil
+}
+
+func (l lineCodec) Decode(reader *bufio.Reader) ([]byte, error) {
+	data, err := readUntil(reader, lineDelimiter, l.maxLength)
+	if err != nil {
+		return nil, unexpectedEOF(data, err)
+	}
+	return trimLine(data), nil
+}
+
+// readFull 读满 length 字节，读取到一半遇到 EOF 时返回 io.ErrUnexpectedEOF
+func readFull(reader *bufio.Reader, length int) ([]byte, error) {
+	buffer := make([]byte, length)
+	if _, err := io.ReadFull(reader, buffer); err != nil {
+		return nil, err
+	}
+	return buffer, nil
+}
+
+// readUntil 读取到 delimiter 为止，返回的数据包含 delimiter，分隔符需要完全匹配
+// 超过 maxLength（包含分隔符）仍未读到分隔符时，继续读取并丢弃到下一个分隔符（包含）为止，然后返回 ErrLineTooLong，
+// 下一次读取从超长行之后开始；maxLength 为 0 表示不限制
+// 读到分隔符之前遇到 EOF 时返回已经读取的数据和 io.EOF
+func readUntil(reader *bufio.Reader, delimiter []byte, maxLength int, retry ...Retry) ([]byte, error) {
+	last := delimiter[len(delimiter)-1]
+	var data []byte
+	r := newRetrier(retry...)
+	for {
+		line, err := reader.ReadSlice(last)
+		if maxLength > 0 && len(data)+len(line) > maxLength {
+			if err = discardUntil(reader, delimiter, append(data, line...), &r); err != nil {
+				return nil, err
+			}
+			return nil, ErrLineTooLong
+		}
+		data = append(data, line...)
+		if err == nil {
+			if bytes.HasSuffix(data, delimiter) {
+				r.succeed()
+				return data, nil
+			}
+			continue
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if r.retry(err) {
+			continue
+		}
+		return data, err
+	}
+}
+
+// discardUntil 丢弃数据直到读到 delimiter（包含）为止，tail 为已经读取的数据，用于匹配跨越两次读取的分隔符
+// 内存占用不随丢弃的数据增长，丢弃时遇到读取错误返回该错误
+func discardUntil(reader *bufio.Reader, delimiter []byte, tail []byte, r *retrier) error {
+	last := delimiter[len(delimiter)-1]
+	for {
+		if bytes.HasSuffix(tail, delimiter) {
+			return nil
+		}
+		if len(tail) >= len(delimiter) {
+			tail = append(tail[:0], tail[len(tail)-len(delimiter)+1:]...)
+		}
+		line, err := reader.ReadSlice(last)
+		tail = append(tail, line...)
+		if err == nil || err == bufio.ErrBufferFull || r.retry(err) {
+			continue
+		}
+		return err
+	}
+}
+
+// SetCodec 设置 SendMsg/RecvMsg 使用的分帧方式，为 nil 时使用默认的 2 字节长度前缀
+func (c *Conn) SetCodec(codec Codec) {
+	c.codec = codec
+}
+
+func (c *Conn) Codec() Codec {
+	if c.codec == nil {
+		return defaultCodec
+	}
+	return c.codec
+}
+
+// SendMsg 使用连接的 Codec 编码并发送一条消息
+func (c *Conn) SendMsg(data []byte, retry ...Retry) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	buffer, err := c.Codec().Encode(data)
+	if err != nil {
+		return err
+	}
+	return c.send(buffer, retry...)
+}
+
+// RecvMsg 使用连接的 Codec 读取一条消息
+func (c *Conn) RecvMsg() ([]byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	var (
+		data []byte
+		err  error
+	)
+	if p, ok := c.Codec().(*pkgCodec); ok {
+		// 经过 readPkg 处理心跳包，收到 ping 时回复 pong
+		_, data, err = c.readPkg(p.option, func(length int) ([]byte, error) {
+			return readFull(c.reader, length)
+		})
+	} else {
+		data, err = c.Codec().Decode(c.reader)
+	}
+	if err != nil {
+		c.reportError(err)
+	}
+	return data, err
+}
+
+// SendMsg 与 Conn.SendMsg 一样在写锁内编码，失败时与 Send 一样重新获取连接，新连接沿用原来的 Codec
+func (c *PoolConn) SendMsg(data []byte, retry ...Retry) error {
+	err := c.Conn.SendMsg(data, retry...)
+	if err != nil && (c.status == connStatusUnknown || c.status == connStatusError) {
+		if v, e := c.pool.Get(); e == nil {
+			codec := c.codec
+			c.Conn = v.(*PoolConn).Conn
+			c.Conn.SetCodec(codec)
+			err = c.Conn.SendMsg(data, retry...)
+		} else {
+			err = e
+		}
+	}
+	return c.setStatus(err)
+}
+
+func (c *PoolConn) RecvMsg() ([]byte, error) {
+	data, err := c.Conn.RecvMsg()
+	if err != nil {
+		c.status = connStatusError
+	} else {
+		c.status = connStatusActive
+	}
+	return data, err
+}
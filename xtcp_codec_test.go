@@ -0,0 +1,275 @@
+package xtcp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/motai3/xtcp"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+// newPkgCodec 创建 pkg 分帧，参数不合法时测试失败
+func newPkgCodec(t testing.TB, option ...xtcp.PkgOption) xtcp.Codec {
+	codec, err := xtcp.NewPkgCodec(option...)
+	assert.NoError(t, err)
+	return codec
+}
+
+func Test_Codec_Decode(t *testing.T) {
+	t.Run("Pkg", func(t *testing.T) {
+		codec := newPkgCodec(t, xtcp.PkgOption{HeaderSize: 1})
+		frame, err := codec.Encode([]byte("abc"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{3, 'a', 'b', 'c'}, frame)
+		data, err := codec.Decode(bufio.NewReader(bytes.NewReader(frame)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("abc"), data)
+		_, err = codec.Decode(bufio.NewReader(bytes.NewReader(frame[:2])))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+
+	t.Run("Delimiter", func(t *testing.T) {
+		codec, err := xtcp.NewDelimiterCodec([]byte("$$"))
+		assert.NoError(t, err)
+		reader := bufio.NewReader(bytes.NewReader([]byte("a$b$$c$$")))
+		data, err := codec.Decode(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("a$b"), data)
+		data, err = codec.Decode(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("c"), data)
+		_, err = codec.Decode(reader)
+		assert.Equal(t, io.EOF, err)
+		_, err = codec.Encode([]byte("x$$"))
+		assert.Error(t, err)
+		_, err = xtcp.NewDelimiterCodec(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("FixedLength", func(t *testing.T) {
+		codec, err := xtcp.NewFixedLengthCodec(2)
+		assert.NoError(t, err)
+		reader := bufio.NewReader(bytes.NewReader([]byte("abcd")))
+		data, err := codec.Decode(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("ab"), data)
+		_, err = codec.Encode([]byte("abc"))
+		assert.Error(t, err)
+		_, err = xtcp.NewFixedLengthCodec(0)
+		assert.Error(t, err)
+	})
+
+	t.Run("Line", func(t *testing.T) {
+		codec := xtcp.NewLineCodec()
+		reader := bufio.NewReader(bytes.NewReader([]byte("one\r\ntwo\n")))
+		data, err := codec.Decode(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("one"), data)
+		data, err = codec.Decode(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("two"), data)
+	})
+}
+
+func Test_Codec_Server(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvMsg()
+			if err != nil {
+				break
+			}
+			conn.SendMsg(append([]byte(">"), data...))
+		}
+	})
+	assert.NoError(t, server.SetCodec(xtcp.NewLineCodec()))
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetCodec(xtcp.NewLineCodec())
+	for _, line := range []string{"hello", "hi there"} {
+		assert.NoError(t, conn.SendMsg([]byte(line)))
+		data, err := conn.RecvMsg()
+		assert.NoError(t, err)
+		assert.Equal(t, ">"+line, string(data))
+	}
+}
+
+func Test_Codec_PkgLayout(t *testing.T) {
+	decode := func(codec xtcp.Codec, frame []byte) []byte {
+		data, err := codec.Decode(bufio.NewReader(bytes.NewReader(frame)))
+		assert.NoError(t, err)
+		return data
+	}
+
+	t.Run("LittleEndian", func(t *testing.T) {
+		codec := newPkgCodec(t, xtcp.PkgOption{HeaderSize: 2, ByteOrder: binary.LittleEndian})
+		frame, err := codec.Encode(make([]byte, 0x102))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0x02, 0x01}, frame[:2])
+		assert.Len(t, decode(codec, frame), 0x102)
+	})
+
+	t.Run("Varint", func(t *testing.T) {
+		codec := newPkgCodec(t, xtcp.PkgOption{Varint: true})
+		frame, err := codec.Encode(make([]byte, 300))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0xAC, 0x02}, frame[:2])
+		assert.Len(t, frame, 302)
+		assert.Len(t, decode(codec, frame), 300)
+	})
+
+	t.Run("OffsetAndAdjustment", func(t *testing.T) {
+		// 魔数 0xCA + 2 字节长度，长度包含整个包头
+		option := xtcp.PkgOption{
+			HeaderSize:        2,
+			LengthFieldOffset: 1,
+			HeaderPrefix:      []byte{0xCA},
+			LengthAdjustment:  -3,
+		}
+		codec := newPkgCodec(t, option)
+		frame, err := codec.Encode([]byte("hi"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0xCA, 0x00, 0x05, 'h', 'i'}, frame)
+		assert.Equal(t, []byte("hi"), decode(codec, frame))
+
+		option.InitialBytesToStrip = 1
+		assert.Equal(t, []byte{0x00, 0x05, 'h', 'i'}, decode(newPkgCodec(t, option), frame))
+
+		option.InitialBytesToStrip = xtcp.PkgStripNone
+		assert.Equal(t, frame, decode(newPkgCodec(t, option), frame))
+	})
+
+	t.Run("NegativeLength", func(t *testing.T) {
+		// 长度字段小于 LengthAdjustment 的绝对值
+		codec := newPkgCodec(t, xtcp.PkgOption{HeaderSize: 2, LengthAdjustment: -3})
+		_, err := codec.Decode(bufio.NewReader(bytes.NewReader([]byte{0x00, 0x01, 'a'})))
+		assert.True(t, errors.Is(err, xtcp.ErrPkgInvalidLength))
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		codec := newPkgCodec(t, xtcp.PkgOption{HeaderSize: 1, MaxDataSize: 0xFF, LengthAdjustment: -1})
+		_, err := codec.Encode(make([]byte, 0xFF))
+		assert.Error(t, err)
+	})
+}
+
+func Test_Codec_PkgFrame(t *testing.T) {
+	option := xtcp.PkgOption{
+		HeaderSize: 4,
+		Frame: &xtcp.PkgFrame{
+			Magic:    []byte{0xCA, 0xFE},
+			Version:  1,
+			Flags:    0x10,
+			Checksum: xtcp.PkgChecksumCRC32C,
+		},
+	}
+	codec := newPkgCodec(t, option)
+	frame, err := codec.Encode([]byte("payload"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xCA, 0xFE, 1, 0x10, 0, 0, 0, 7}, frame[:8])
+	assert.Len(t, frame, 8+4+7)
+
+	decode := func(frame []byte) ([]byte, error) {
+		return codec.Decode(bufio.NewReader(bytes.NewReader(frame)))
+	}
+
+	t.Run("Basic", func(t *testing.T) {
+		data, err := decode(frame)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("payload"), data)
+	})
+
+	t.Run("BadMagic", func(t *testing.T) {
+		bad := append([]byte{}, frame...)
+		bad[0] = 0
+		_, err := decode(bad)
+		assert.True(t, errors.Is(err, xtcp.ErrPkgBadMagic))
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		bad := append([]byte{}, frame...)
+		bad[2] = 2
+		_, err := decode(bad)
+		assert.True(t, errors.Is(err, xtcp.ErrPkgVersion))
+	})
+
+	t.Run("Checksum", func(t *testing.T) {
+		bad := append([]byte{}, frame...)
+		bad[len(bad)-1] ^= 0xFF
+		_, err := decode(bad)
+		assert.True(t, errors.Is(err, xtcp.ErrPkgChecksum))
+	})
+
+	t.Run("Partial", func(t *testing.T) {
+		_, err := decode(frame[:10])
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+
+	t.Run("ReservedFlags", func(t *testing.T) {
+		_, err := xtcp.NewPkgCodec(xtcp.PkgOption{Frame: &xtcp.PkgFrame{Flags: 0x01}})
+		assert.Error(t, err)
+	})
+
+	t.Run("RecvPkgFlags", func(t *testing.T) {
+		p := portList.PopFront().(int)
+		server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+			defer conn.Close()
+			conn.SendPkg([]byte("pong"), option)
+		})
+		go server.Run()
+		defer server.Close()
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+		assert.NoError(t, err)
+		defer conn.Close()
+		data, flags, err := conn.RecvPkgFlags(option)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("pong"), data)
+		assert.Equal(t, byte(0x10), flags)
+	})
+}
+
+func Test_Codec_PkgCompress(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"key":"value"},`), 1000)
+	for name, compressor := range map[string]xtcp.Compressor{
+		"Flate": xtcp.NewFlateCompressor(),
+		"Gzip":  xtcp.NewGzipCompressor(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			option := xtcp.PkgOption{
+				HeaderSize: 4,
+				Frame:      &xtcp.PkgFrame{Checksum: xtcp.PkgChecksumCRC32},
+				Compressor: compressor,
+			}
+			codec := newPkgCodec(t, option)
+			frame, err := codec.Encode(data)
+			assert.NoError(t, err)
+			assert.True(t, len(frame) < len(data)/10)
+			assert.Equal(t, xtcp.PkgFlagCompressed, frame[1])
+			result, err := codec.Decode(bufio.NewReader(bytes.NewReader(frame)))
+			assert.NoError(t, err)
+			assert.Equal(t, data, result)
+
+			small, err := codec.Encode([]byte("tiny"))
+			assert.NoError(t, err)
+			assert.Equal(t, byte(0), small[1])
+
+			option.MaxDecompressedSize = 1024
+			_, err = newPkgCodec(t, option).Decode(bufio.NewReader(bytes.NewReader(frame)))
+			assert.Equal(t, xtcp.ErrPkgDecompressedTooLarge, err)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package xtcp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// PkgFlagCompressed 标志位，表示数据经过了压缩
+const PkgFlagCompressed byte = 0x01
+
+// 默认达到该长度才压缩
+const defaultCompressThreshold = 1024
+
+// Compressor 压缩算法，zstd、snappy 等第三方算法实现该接口即可接入
+// Decompress 解压后的数据超过 maxSize 时需要返回 ErrPkgDecompressedTooLarge
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte, maxSize int) ([]byte, error)
+}
+
+// ErrPkgDecompressedTooLarge 解压后的数据超过了 MaxDecompressedSize
+var ErrPkgDecompressedTooLarge = errors.New("pkg decompressed size exceeds limit")
+
+type flateCompressor struct {
+	level int
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+// NewFlateCompressor 创建 deflate 压缩，level 取值同 compress/flate
+func NewFlateCompressor(level ...int) Compressor {
+	c := &flateCompressor{level: flate.DefaultCompression}
+	if len(level) > 0 {
+		c.level = level[0]
+	}
+	return c
+}
+
+// NewGzipCompressor 创建 gzip 压缩，level 取值同 compress/gzip
+func NewGzipCompressor(level ...int) Compressor {
+	c := &gzipCompressor{level: gzip.DefaultCompression}
+	if len(level) > 0 {
+		c.level = level[0]
+	}
+	return c
+}
+
+func (c *flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	w, err := flate.NewWriter(&buffer, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readLimited(r, maxSize)
+}
+
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buffer, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, maxSize)
+}
+
+// readLimited 最多读取 maxSize 字节，超过时返回 ErrPkgDecompressedTooLarge
+func readLimited(r io.Reader, maxSize int) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, ErrPkgDecompressedTooLarge
+	}
+	return data, nil
+}
+
+// compress 数据达到阈值并且压缩后变小时返回压缩后的数据和 PkgFlagCompressed
+func (o *PkgOption) compress(data []byte) ([]byte, byte, error) {
+	if o.Compressor == nil || len(data) < o.CompressThreshold {
+		return data, 0, nil
+	}
+	compressed, err := o.Compressor.Compress(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(compressed) >= len(data) {
+		return data, 0, nil
+	}
+	return compressed, PkgFlagCompressed, nil
+}
+
+func (o *PkgOption) decompress(flags byte, data []byte) ([]byte, error) {
+	if flags&PkgFlagCompressed == 0 {
+		return data, nil
+	}
+	if o.Compressor == nil {
+		return nil, errors.New("received compressed pkg but Compressor is not configured")
+	}
+	return o.Compressor.Decompress(data, o.MaxDecompressedSize)
+}
@@ -2,34 +2,73 @@ package xtcp
 
 import (
 	"bufio"
-	"bytes"
 	"crypto/tls"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Conn 的发送方法之间、接收方法之间都是并发安全的：每次 Send/SendPkg 等调用写入的数据不会与其他 goroutine 交错，
+// 每次 Recv/RecvPkg 等调用读取的也是完整的一条消息
+// WithTimeout 和 Ctx 方法在持有读锁或写锁之后才修改 deadline，返回前恢复，不影响其他 goroutine 的读写；
+// 直接调用 SetDeadline、SetSendDeadline 等会影响所有正在进行的读写
 type Conn struct {
+	lastActive      int64 // 最后一次读写的时间，UnixNano，原子操作，放在首位保证 32 位平台对齐
+	bytesRead       int64
+	bytesWritten    int64
+	lastRead        int64 // 最后一次读到数据的时间，UnixNano，用于心跳判断对端失联
+	peerClosedWrite int32
 	net.Conn
 	reader            *bufio.Reader
 	receiveDeadline   time.Time
 	sendDeadline      time.Time
 	receiveBufferWait time.Duration //读取缓冲的间隔时间
+	writeMu           sync.Mutex    // 保证一次发送的数据连续写入
+	readMu            sync.Mutex    // 保证一次接收读取完整的消息
+	id                uint64
+	connectTime       time.Time
+	mu                sync.RWMutex
+	principal         interface{}
+	attributes        map[string]interface{}
+	hooks             ConnHooks
+	closeOnce         sync.Once
+	lastErr           error // 最后一次读写错误，作为关闭原因
+	closeReason       error // CloseWithReason 指定的关闭原因
+	codec             Codec
+	serializer        Serializer
+	writeBuffer       *writeBuffer
+	maxLineLength     int
+	heartbeatStop     chan struct{}
+}
+
+// connReader 为 bufio.Reader 提供数据源，记录连接的读活动
+type connReader struct {
+	c *Conn
+}
+
+// connWriter 写入底层连接，记录连接的写活动
+type connWriter struct {
+	c *Conn
 }
 
 const receiveAllWaitTimeout = time.Millisecond
 
+// 连接 ID 生成器
+var connIdSeq uint64
+
 func NewConn(addr string, timeout ...time.Duration) (*Conn, error) {
 	if conn, err := NewNetConn(addr, timeout...); err == nil {
-		return NewConnByNetConn(conn), nil
+		return newClientConn(conn)
 	} else {
 		return nil, err
 	}
 }
 
-func NewConnTLS(addr string, tlsConfig *tls.Config) (*Conn, error) {
-	if conn, err := NewNetConnTLS(addr, tlsConfig); err == nil {
-		return NewConnByNetConn(conn), nil
+func NewConnTLS(addr string, tlsConfig *tls.Config, timeout ...time.Duration) (*Conn, error) {
+	if conn, err := NewNetConnTLS(addr, tlsConfig, timeout...); err == nil {
+		return newClientConn(conn)
 	} else {
 		return nil, err
 	}
@@ -37,46 +76,86 @@ func NewConnTLS(addr string, tlsConfig *tls.Config) (*Conn, error) {
 
 func NewConnByKeyCrt(addr, crtFile, keyFile string) (*Conn, error) {
 	if conn, err := NewNetConnKeyCrt(addr, crtFile, keyFile); err == nil {
-		return NewConnByNetConn(conn), nil
+		return newClientConn(conn)
 	} else {
 		return nil, err
 	}
 }
 
 func NewConnByNetConn(conn net.Conn) *Conn {
-	return &Conn{
+	now := time.Now()
+	c := &Conn{
 		Conn:              conn,
-		reader:            bufio.NewReader(conn),
 		receiveDeadline:   time.Time{},
 		sendDeadline:      time.Time{},
 		receiveBufferWait: receiveAllWaitTimeout,
+		maxLineLength:     defaultMaxLineLength,
+		id:                atomic.AddUint64(&connIdSeq, 1),
+		connectTime:       now,
+		lastActive:        now.UnixNano(),
+		lastRead:          now.UnixNano(),
 	}
+	c.reader = bufio.NewReader(connReader{c})
+	return c
+}
+
+func (r connReader) Read(p []byte) (int, error) {
+	// 阻塞读取之前先发出缓冲中的数据，避免请求停留在写缓冲中等待响应，写入在单独的协程中进行，读取不会等待写入
+	if r.c.writeBuffer != nil {
+		r.c.flushAsync()
+	}
+	n, err := r.c.Conn.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&r.c.bytesRead, int64(n))
+		now := time.Now().UnixNano()
+		atomic.StoreInt64(&r.c.lastRead, now)
+		atomic.StoreInt64(&r.c.lastActive, now)
+	}
+	if err == io.EOF {
+		atomic.StoreInt32(&r.c.peerClosedWrite, 1)
+	}
+	return n, err
+}
+
+func (w connWriter) Write(p []byte) (int, error) {
+	n, err := w.c.Conn.Write(p)
+	if n > 0 {
+		atomic.AddInt64(&w.c.bytesWritten, int64(n))
+		w.c.touch()
+	}
+	return n, err
 }
 
 func (c *Conn) Send(data []byte, retry ...Retry) error {
-	for {
-		if _, err := c.Write(data); err != nil {
-			if err == io.EOF {
-				return err
-			}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.send(data, retry...)
+}
 
-			if len(retry) == 0 || retry[0].Count == 0 {
-				return err
-			}
-			if len(retry) > 0 {
-				retry[0].Count--
-				if retry[0].Interval == 0 {
-					retry[0].Interval = defaultRetryInternal
-				}
-				time.Sleep(retry[0].Interval)
-			}
-		} else {
+func (c *Conn) send(data []byte, retry ...Retry) error {
+	r := newRetrier(retry...)
+	for {
+		n, err := c.write(data)
+		if err == nil {
+			r.succeed()
 			return nil
 		}
+		// 部分写入后重试时只发送剩余的数据，避免对端收到重复的内容
+		data = data[n:]
+		if !r.retry(err) {
+			c.reportError(err)
+			return err
+		}
 	}
 }
 
 func (c *Conn) Recv(length int, retry ...Retry) ([]byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.recv(length, retry...)
+}
+
+func (c *Conn) recv(length int, retry ...Retry) ([]byte, error) {
 	var err error
 	var size int
 	var index int
@@ -85,10 +164,12 @@ func (c *Conn) Recv(length int, retry ...Retry) ([]byte, error) {
 
 	if length > 0 {
 		buffer = make([]byte, length)
-	} else {
-		buffer = make([]byte, defaultReadBufferSize)
+		index, err = c.recvInto(buffer, retry...)
+		return buffer[:index], err
 	}
+	buffer = make([]byte, defaultReadBufferSize)
 
+	r := newRetrier(retry...)
 	for {
 		if length < 0 && index > 0 {
 			bufferWait = true
@@ -99,18 +180,10 @@ func (c *Conn) Recv(length int, retry ...Retry) ([]byte, error) {
 		size, err = c.reader.Read(buffer[index:])
 		if size > 0 {
 			index += size
-			if length > 0 {
-				if index == length {
-					break
-				}
-			} else {
-				if index >= defaultReadBufferSize {
-					buffer = append(buffer, make([]byte, defaultReadBufferSize)...)
-				} else {
-					if !bufferWait {
-						break
-					}
-				}
+			if index == len(buffer) {
+				buffer = append(buffer, make([]byte, len(buffer))...)
+			} else if !bufferWait {
+				break
 			}
 		}
 		if err != nil {
@@ -118,23 +191,14 @@ func (c *Conn) Recv(length int, retry ...Retry) ([]byte, error) {
 				break
 			}
 			if bufferWait && isTimeout(err) {
-				if err = c.SetReadDeadline(c.sendDeadline); err != nil {
+				if err = c.SetReadDeadline(c.getReceiveDeadline()); err != nil {
 					return nil, err
 				}
 				err = nil
 				break
 			}
 
-			if len(retry) > 0 {
-				// It fails even it retried.
-				if retry[0].Count == 0 {
-					break
-				}
-				retry[0].Count--
-				if retry[0].Interval == 0 {
-					retry[0].Interval = defaultRetryInternal
-				}
-				time.Sleep(retry[0].Interval)
+			if r.retry(err) {
 				continue
 			}
 			break
@@ -143,71 +207,65 @@ func (c *Conn) Recv(length int, retry ...Retry) ([]byte, error) {
 			break
 		}
 	}
+	if err != nil {
+		c.reportError(err)
+	} else {
+		r.succeed()
+	}
 	return buffer[:index], err
 }
 
-func (c *Conn) RecvLine(retry ...Retry) ([]byte, error) {
-	var err error
-	var buffer []byte
-	data := make([]byte, 0)
-	for {
-		buffer, err = c.Recv(1, retry...)
-		if len(buffer) > 0 {
-			if buffer[0] == '\n' {
-				data = append(data, buffer[:len(buffer)-1]...)
-			} else {
-				data = append(data, buffer...)
-			}
-		}
-		if err != nil {
-			break
-		}
-	}
-	return data, err
+// RecvInto 读满 buffer，不分配内存，返回读取的字节数
+// 读取到一半遇到对端关闭时返回 io.ErrUnexpectedEOF
+func (c *Conn) RecvInto(buffer []byte, retry ...Retry) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.recvInto(buffer, retry...)
 }
 
-// RecvTil 自定义分隔符，包含分隔符
-func (c *Conn) RecvTil(til []byte, retry ...Retry) ([]byte, error) {
+func (c *Conn) recvInto(buffer []byte, retry ...Retry) (int, error) {
 	var err error
-	var buffer []byte
-	data := make([]byte, 0)
-	length := len(til)
-	for {
-		buffer, err = c.Recv(1, retry...)
-		if len(buffer) > 0 {
-			if length > 0 &&
-				len(data) >= length-1 &&
-				buffer[0] == til[length-1] &&
-				bytes.EqualFold(data[len(data)-length+1:], til[:length-1]) {
-				data = append(data, buffer...)
+	var size int
+	var index int
+	r := newRetrier(retry...)
+	for index < len(buffer) {
+		size, err = c.reader.Read(buffer[index:])
+		index += size
+		if err != nil {
+			if err == io.EOF {
 				break
-			} else {
-				data = append(data, buffer...)
 			}
-		}
-		if err != nil {
+			if r.retry(err) {
+				continue
+			}
 			break
 		}
 	}
-	return data, err
+	if index == len(buffer) {
+		r.succeed()
+		return index, nil
+	}
+	if err == io.EOF && index > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.reportError(err)
+	}
+	return index, err
 }
 
 func (c *Conn) RecvWithTimeout(length int, timeout time.Duration, retry ...Retry) (data []byte, err error) {
-	if err := c.SetreceiveDeadline(time.Now().Add(timeout)); err != nil {
-		return nil, err
-	}
-	defer c.SetreceiveDeadline(time.Time{})
-	data, err = c.Recv(length, retry...)
+	err = c.withDeadline(true, time.Now().Add(timeout), func() error {
+		data, err = c.recv(length, retry...)
+		return err
+	})
 	return
 }
 
-func (c *Conn) SendWithTimeout(data []byte, timeout time.Duration, retry ...Retry) (err error) {
-	if err := c.SetSendDeadline(time.Now().Add(timeout)); err != nil {
-		return err
-	}
-	defer c.SetSendDeadline(time.Time{})
-	err = c.Send(data, retry...)
-	return
+func (c *Conn) SendWithTimeout(data []byte, timeout time.Duration, retry ...Retry) error {
+	return c.withDeadline(false, time.Now().Add(timeout), func() error {
+		return c.send(data, retry...)
+	})
 }
 
 func (c *Conn) SendRecv(data []byte, length int, retry ...Retry) ([]byte, error) {
@@ -230,8 +288,10 @@ func (c *Conn) SendRecvWithTimeout(data []byte, length int, timeout time.Duratio
 func (c *Conn) SetDeadline(t time.Time) error {
 	err := c.Conn.SetDeadline(t)
 	if err == nil {
+		c.mu.Lock()
 		c.receiveDeadline = t
 		c.sendDeadline = t
+		c.mu.Unlock()
 	}
 	return err
 }
@@ -239,7 +299,9 @@ func (c *Conn) SetDeadline(t time.Time) error {
 func (c *Conn) SetreceiveDeadline(t time.Time) error {
 	err := c.SetReadDeadline(t)
 	if err == nil {
+		c.mu.Lock()
 		c.receiveDeadline = t
+		c.mu.Unlock()
 	}
 	return err
 }
@@ -247,11 +309,25 @@ func (c *Conn) SetreceiveDeadline(t time.Time) error {
 func (c *Conn) SetSendDeadline(t time.Time) error {
 	err := c.SetWriteDeadline(t)
 	if err == nil {
+		c.mu.Lock()
 		c.sendDeadline = t
+		c.mu.Unlock()
 	}
 	return err
 }
 
+func (c *Conn) getReceiveDeadline() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.receiveDeadline
+}
+
+func (c *Conn) getSendDeadline() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sendDeadline
+}
+
 // SetreceiveBufferWait sets the buffer waiting timeout when reading all data from connection.
 // The waiting duration cannot be too long which might delay receiving data from remote address.
 func (c *Conn) SetreceiveBufferWait(bufferWaitDuration time.Duration) {
@@ -0,0 +1,76 @@
+package xtcp
+
+import (
+	"net"
+)
+
+// SendPkgBatch 把多个包通过一次 writev 写入连接，包头和数据不需要拷贝到同一个缓冲
+// 底层连接不支持 writev 时（例如 TLS）退化为依次写入
+func (c *Conn) SendPkgBatch(datas [][]byte, option ...PkgOption) error {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	headers := make([]byte, 0, len(datas)*pkgOption.headerCap())
+	offsets := make([]int, len(datas)+1)
+	payloads := make([][]byte, len(datas))
+	if pkgOption.Frame == nil {
+		for i, data := range datas {
+			if headers, payloads[i], err = pkgOption.appendHeader(headers, data); err != nil {
+				return err
+			}
+			offsets[i+1] = len(headers)
+		}
+	} else {
+		// 先压缩并检查所有包的长度再加密，有包不合法时整批返回错误，不会推进加密的序列号
+		flags := make([]byte, len(datas))
+		for i, data := range datas {
+			if flags[i], payloads[i], err = pkgOption.compressFrame(0, data); err != nil {
+				return err
+			}
+			if err = pkgOption.checkFrameLength(payloads[i]); err != nil {
+				return err
+			}
+		}
+		for i := range payloads {
+			if headers, payloads[i], err = pkgOption.sealFrame(headers, flags[i], payloads[i]); err != nil {
+				return err
+			}
+			offsets[i+1] = len(headers)
+		}
+	}
+	buffers := make(net.Buffers, 0, 2*len(datas))
+	for i := range datas {
+		buffers = append(buffers, headers[offsets[i]:offsets[i+1]])
+		if len(payloads[i]) > 0 {
+			buffers = append(buffers, payloads[i])
+		}
+	}
+	return c.sendBuffers(buffers, pkgOption.Retry)
+}
+
+// sendBuffers 写入多个缓冲，失败重试时从未写入的位置继续
+func (c *Conn) sendBuffers(buffers net.Buffers, retry ...Retry) error {
+	r := newRetrier(retry...)
+	for {
+		if _, err := c.writeBuffers(&buffers); err == nil {
+			r.succeed()
+			return nil
+		} else if !r.retry(err) {
+			c.reportError(err)
+			return err
+		}
+	}
+}
+
+func (c *PoolConn) SendPkgBatch(datas [][]byte, option ...PkgOption) error {
+	err := c.Conn.SendPkgBatch(datas, option...)
+	if err != nil {
+		c.status = connStatusError
+	} else {
+		c.status = connStatusActive
+	}
+	return err
+}
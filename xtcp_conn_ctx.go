@@ -0,0 +1,183 @@
+package xtcp
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// 用于立即打断阻塞读写的过去时间
+var aLongTimeAgo = time.Unix(1, 0)
+
+func (c *Conn) SendCtx(ctx context.Context, data []byte, retry ...Retry) error {
+	return c.withContext(ctx, false, func() error {
+		return c.send(data, withRetryContext(ctx, retry)...)
+	})
+}
+
+func (c *Conn) RecvCtx(ctx context.Context, length int, retry ...Retry) (data []byte, err error) {
+	err = c.withContext(ctx, true, func() error {
+		data, err = c.recv(length, withRetryContext(ctx, retry)...)
+		return err
+	})
+	return
+}
+
+func (c *Conn) SendRecvCtx(ctx context.Context, data []byte, length int, retry ...Retry) ([]byte, error) {
+	if err := c.SendCtx(ctx, data, retry...); err != nil {
+		return nil, err
+	}
+	return c.RecvCtx(ctx, length, retry...)
+}
+
+// RecvLineCtx 与 RecvLine 相同，ctx 结束时打断读取，已经读取的半行数据会丢失
+func (c *Conn) RecvLineCtx(ctx context.Context, retry ...Retry) (data []byte, err error) {
+	err = c.withContext(ctx, true, func() error {
+		data, err = c.recvLine(withRetryContext(ctx, retry)...)
+		return err
+	})
+	return
+}
+
+// RecvTilCtx 与 RecvTil 相同，ctx 结束时打断读取，已经读取的数据会丢失
+func (c *Conn) RecvTilCtx(ctx context.Context, til []byte, retry ...Retry) (data []byte, err error) {
+	if len(til) == 0 {
+		return nil, errors.New("delimiter is empty")
+	}
+	err = c.withContext(ctx, true, func() error {
+		data, err = c.recvTil(til, withRetryContext(ctx, retry)...)
+		return err
+	})
+	return
+}
+
+// SendPkgCtx 发送一个包，ctx 结束时打断写入，此时对端可能只收到了半个包，连接不应该再继续使用
+func (c *Conn) SendPkgCtx(ctx context.Context, data []byte, option ...PkgOption) error {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
+		return err
+	}
+	return c.withContext(ctx, false, func() error {
+		return c.sendPkg(data, &pkgOption)
+	})
+}
+
+func (c *Conn) RecvPkgCtx(ctx context.Context, option ...PkgOption) (data []byte, err error) {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
+		return nil, err
+	}
+	err = c.withContext(ctx, true, func() error {
+		data, err = c.recvPkg(&pkgOption)
+		return err
+	})
+	return
+}
+
+func (c *Conn) SendRecvPkgCtx(ctx context.Context, data []byte, option ...PkgOption) ([]byte, error) {
+	if err := c.SendPkgCtx(ctx, data, option...); err != nil {
+		return nil, err
+	}
+	return c.RecvPkgCtx(ctx, option...)
+}
+
+// withContext 持有读锁（read 为 true）或写锁时执行 fn，把 ctx 的截止时间设置为接收/发送 deadline，
+// ctx 取消时把底层连接的 deadline 设置为过去时间来打断阻塞的读写
+// deadline 只在持有锁时修改，fn 返回后恢复原来的值，不会影响其他 goroutine 的读写；因 ctx 结束导致的失败返回 ctx.Err()
+// 等待锁的时间不受 ctx 控制
+func (c *Conn) withContext(ctx context.Context, read bool, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	unlock := c.lock(read)
+	defer unlock()
+	run := fn
+	if ctx.Done() != nil {
+		run = func() error {
+			done := make(chan struct{})
+			stopped := make(chan struct{})
+			interrupted := false
+			go func() {
+				defer close(stopped)
+				select {
+				case <-ctx.Done():
+					interrupted = true
+					if read {
+						c.Conn.SetReadDeadline(aLongTimeAgo)
+					} else {
+						c.Conn.SetWriteDeadline(aLongTimeAgo)
+					}
+				case <-done:
+				}
+			}()
+			// 等待协程退出后再恢复 deadline，避免恢复之后又被设置为过去时间
+			defer func() {
+				close(done)
+				<-stopped
+				// 被打断时只修改了底层连接的 deadline，这里恢复为记录的值
+				if !interrupted {
+					return
+				}
+				if read {
+					c.Conn.SetReadDeadline(c.getReceiveDeadline())
+				} else {
+					c.Conn.SetWriteDeadline(c.getSendDeadline())
+				}
+			}()
+			return fn()
+		}
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	var err error
+	if hasDeadline {
+		err = c.withDeadlineLocked(read, deadline, run)
+	} else {
+		err = run()
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// 连接的 deadline 可能比 ctx 的定时器先触发
+	if hasDeadline && isTimeout(err) && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// withDeadline 持有读锁（read 为 true）或写锁时，使用 deadline 作为接收/发送 deadline 执行 fn
+func (c *Conn) withDeadline(read bool, deadline time.Time, fn func() error) error {
+	unlock := c.lock(read)
+	defer unlock()
+	return c.withDeadlineLocked(read, deadline, fn)
+}
+
+// withDeadlineLocked 设置接收/发送 deadline 后执行 fn，返回前恢复原来的值，调用方需要持有对应的锁
+func (c *Conn) withDeadlineLocked(read bool, deadline time.Time, fn func() error) error {
+	set := c.SetSendDeadline
+	if read {
+		set = c.SetreceiveDeadline
+	}
+	previous := c.getDeadline(read)
+	if err := set(deadline); err != nil {
+		return err
+	}
+	defer set(previous)
+	return fn()
+}
+
+// lock 获取读锁或写锁，返回释放函数
+func (c *Conn) lock(read bool) func() {
+	if read {
+		c.readMu.Lock()
+		return c.readMu.Unlock
+	}
+	c.writeMu.Lock()
+	return c.writeMu.Unlock
+}
+
+func (c *Conn) getDeadline(read bool) time.Time {
+	if read {
+		return c.getReceiveDeadline()
+	}
+	return c.getSendDeadline()
+}
@@ -0,0 +1,43 @@
+package xtcp
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrHalfCloseUnsupported 底层连接不支持半关闭，例如 TLS 连接的读方向
+var ErrHalfCloseUnsupported = errors.New("half close is not supported by the underlying connection")
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+type closeReader interface {
+	CloseRead() error
+}
+
+// CloseWrite 关闭写方向，对端读取完已发送的数据后会收到 io.EOF，本端仍然可以继续读取
+// TLS 连接会发送 close_notify，对端同样表现为 io.EOF；开启写缓冲时先写入缓冲中的数据
+func (c *Conn) CloseWrite() error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	if conn, ok := c.Conn.(closeWriter); ok {
+		return conn.CloseWrite()
+	}
+	return ErrHalfCloseUnsupported
+}
+
+// CloseRead 关闭读方向，TLS 连接不支持
+func (c *Conn) CloseRead() error {
+	if conn, ok := c.Conn.(closeReader); ok {
+		return conn.CloseRead()
+	}
+	return ErrHalfCloseUnsupported
+}
+
+// PeerClosedWrite 表示对端已经关闭了写方向（读到了 io.EOF），此时本端可能仍然可以发送数据
+// Recv/RecvPkg 在消息边界遇到对端半关闭时返回 io.EOF，消息读取到一半时返回 io.ErrUnexpectedEOF
+func (c *Conn) PeerClosedWrite() bool {
+	return atomic.LoadInt32(&c.peerClosedWrite) == 1
+}
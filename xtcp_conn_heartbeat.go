@@ -0,0 +1,178 @@
+package xtcp
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// PkgFlagPing 标志位，心跳请求，收到后 RecvPkg 自动回复 PkgFlagPong，不会返回给调用方
+	PkgFlagPing byte = 0x04
+	// PkgFlagPong 标志位，心跳响应，RecvPkg 收到后直接跳过
+	PkgFlagPong byte = 0x08
+
+	defaultHeartbeatInterval  = 15 * time.Second
+	defaultHeartbeatMaxMissed = 3
+)
+
+// HeartbeatOption 应用层心跳参数，心跳包使用扩展包头的保留标志位，PkgOption 需要设置 Frame
+// 对端失联由最后一次读到数据的时间判断，需要有协程在循环调用 RecvPkg 等方法读取连接
+type HeartbeatOption struct {
+	Interval  time.Duration // 超过该时间没有读到数据时发送 ping，默认 15 秒
+	MaxMissed int           // 连续多少个间隔没有读到任何数据判定对端失联，默认 3
+	PkgOption PkgOption     // 心跳包的格式，需要与连接上的其他包一致
+	OnDead    func(c *Conn) // 判定对端失联时调用，之后连接会以 ErrHeartbeatTimeout 为原因关闭
+}
+
+var (
+	// ErrHeartbeatTimeout 对端在 Interval * MaxMissed 时间内没有发送任何数据
+	ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+	// ErrHeartbeatCipher 多个连接共用的心跳参数设置了 PkgOption.Cipher，Cipher 保存单个连接的序号，不能共用
+	ErrHeartbeatCipher = errors.New("shared heartbeat option can not use pkg Cipher")
+)
+
+// StartHeartbeat 启动心跳协程，空闲时定时发送 ping，对端失联时关闭连接，连接关闭时自动停止
+// 对端使用 RecvPkg 等方法读取时会自动回复 pong，不需要额外处理
+func (c *Conn) StartHeartbeat(option HeartbeatOption) error {
+	if option.PkgOption.Frame == nil {
+		return errors.New("heartbeat requires pkg Frame")
+	}
+	pkgOption, err := getPkgOption(option.PkgOption)
+	if err != nil {
+		return err
+	}
+	if option.Interval <= 0 {
+		option.Interval = defaultHeartbeatInterval
+	}
+	if option.MaxMissed <= 0 {
+		option.MaxMissed = defaultHeartbeatMaxMissed
+	}
+	option.PkgOption = pkgOption
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.heartbeatStop != nil {
+		return errors.New("heartbeat is already started")
+	}
+	c.heartbeatStop = make(chan struct{})
+	go c.heartbeat(option, c.heartbeatStop)
+	return nil
+}
+
+// Ping 立即发送一个心跳请求
+func (c *Conn) Ping(option ...PkgOption) error {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
+		return err
+	}
+	if pkgOption.Frame == nil {
+		return errors.New("heartbeat requires pkg Frame")
+	}
+	return c.sendControl(&pkgOption, PkgFlagPing)
+}
+
+// LastRead 返回最后一次从连接读到数据的时间
+func (c *Conn) LastRead() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastRead))
+}
+
+// heartbeat 定时检查对端是否失联，ping 在单独的协程中发送，其他协程的写入阻塞时不影响失联判断
+func (c *Conn) heartbeat(option HeartbeatOption, stop chan struct{}) {
+	ticker := time.NewTicker(option.Interval)
+	defer ticker.Stop()
+	deadline := option.Interval * time.Duration(option.MaxMissed)
+	var pinging int32
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		idle := time.Since(c.LastRead())
+		if idle >= deadline {
+			if option.OnDead != nil {
+				option.OnDead(c)
+			}
+			c.CloseWithReason(ErrHeartbeatTimeout)
+			return
+		}
+		if idle >= option.Interval && atomic.CompareAndSwapInt32(&pinging, 0, 1) {
+			go func() {
+				defer atomic.StoreInt32(&pinging, 0)
+				// 超时的 ping 可能只写入了一部分，连接不能再继续使用
+				if err := c.ping(&option.PkgOption, option.Interval); err != nil {
+					c.CloseWithReason(err)
+				}
+			}()
+		}
+	}
+}
+
+// ping 发送心跳请求，拿到写锁之后最多等待 timeout
+func (c *Conn) ping(o *PkgOption, timeout time.Duration) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.withDeadlineLocked(false, time.Now().Add(timeout), func() error {
+		return c.sendControlLocked(o, PkgFlagPing)
+	})
+}
+
+// sendControl 发送不带数据的控制包，开启写缓冲时立即写入连接
+func (c *Conn) sendControl(o *PkgOption, flags byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.sendControlLocked(o, flags)
+}
+
+func (c *Conn) sendControlLocked(o *PkgOption, flags byte) error {
+	buffer, err := o.packFrame(flags, nil)
+	if err != nil {
+		return err
+	}
+	if err = c.send(buffer, o.Retry); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// readPkg 读取一个包，自动回复 ping 并跳过心跳包，返回扩展包头中的标志位
+func (c *Conn) readPkg(o *PkgOption, read func(length int) ([]byte, error)) (byte, []byte, error) {
+	if o.Frame == nil {
+		data, err := o.unpack(read)
+		return 0, data, err
+	}
+	for {
+		flags, data, err := o.unpackFrame(read)
+		if err != nil || !isControl(flags) {
+			return flags, data, err
+		}
+		if flags&PkgFlagPing != 0 {
+			if err = c.sendControl(o, PkgFlagPong); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+}
+
+// checkSharedHeartbeat 检查多个连接共用的心跳参数
+func checkSharedHeartbeat(option *HeartbeatOption) error {
+	if option != nil && option.PkgOption.Cipher != nil {
+		return ErrHeartbeatCipher
+	}
+	return nil
+}
+
+// isControl 判断扩展包头的标志位是否为心跳包
+func isControl(flags byte) bool {
+	return flags&(PkgFlagPing|PkgFlagPong) != 0
+}
+
+// stopHeartbeat 在连接关闭时停止心跳协程
+func (c *Conn) stopHeartbeat() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.heartbeatStop != nil {
+		close(c.heartbeatStop)
+		c.heartbeatStop = nil
+	}
+}
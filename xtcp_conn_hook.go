@@ -0,0 +1,131 @@
+package xtcp
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnHooks 连接生命周期回调，所有回调都可以为空
+type ConnHooks struct {
+	// OnConnect 在服务端 accept 或客户端拨号成功后调用，返回错误会关闭连接
+	OnConnect func(c *Conn) error
+	// OnClose 在连接真正关闭时调用一次
+	OnClose func(c *Conn, info CloseInfo)
+	// OnError 在 Send/Recv 出错时调用，对端正常关闭的 io.EOF 不会触发
+	OnError func(c *Conn, err error)
+}
+
+// CloseInfo 连接关闭时的统计信息
+type CloseInfo struct {
+	Reason       error // 关闭原因，主动关闭且没有发生读写错误时为 nil
+	BytesRead    int64
+	BytesWritten int64
+	Duration     time.Duration
+}
+
+var (
+	clientHooksMu sync.RWMutex
+	clientHooks   ConnHooks
+)
+
+// SetClientHooks 设置客户端连接的全局回调，对 NewConn、NewConnTLS、NewPoolConn 等拨号创建的连接生效
+func SetClientHooks(hooks ConnHooks) {
+	clientHooksMu.Lock()
+	defer clientHooksMu.Unlock()
+	clientHooks = hooks
+}
+
+func getClientHooks() ConnHooks {
+	clientHooksMu.RLock()
+	defer clientHooksMu.RUnlock()
+	return clientHooks
+}
+
+func newClientConn(conn net.Conn) (*Conn, error) {
+	c := NewConnByNetConn(conn)
+	c.hooks = getClientHooks()
+	if err := c.connected(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetOnClose 设置连接关闭回调，需要在连接被并发使用前设置
+func (c *Conn) SetOnClose(fn func(c *Conn, info CloseInfo)) {
+	c.hooks.OnClose = fn
+}
+
+// SetOnError 设置读写错误回调，需要在连接被并发使用前设置
+func (c *Conn) SetOnError(fn func(c *Conn, err error)) {
+	c.hooks.OnError = fn
+}
+
+func (c *Conn) BytesRead() int64 {
+	return atomic.LoadInt64(&c.bytesRead)
+}
+
+func (c *Conn) BytesWritten() int64 {
+	return atomic.LoadInt64(&c.bytesWritten)
+}
+
+// Close 关闭连接，关闭原因为最后一次读写错误
+func (c *Conn) Close() error {
+	return c.CloseWithReason(nil)
+}
+
+// CloseWithReason 使用指定原因关闭连接，reason 为 nil 时使用最后一次读写错误
+func (c *Conn) CloseWithReason(reason error) error {
+	c.flushOnClose()
+	c.stopHeartbeat()
+	// 关闭之前记录原因，避免被关闭导致的读写错误覆盖
+	if reason != nil {
+		c.mu.Lock()
+		if c.closeReason == nil {
+			c.closeReason = reason
+		}
+		c.mu.Unlock()
+	}
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.hooks.OnClose == nil {
+			return
+		}
+		c.mu.RLock()
+		reason = c.closeReason
+		if reason == nil {
+			reason = c.lastErr
+		}
+		c.mu.RUnlock()
+		c.hooks.OnClose(c, CloseInfo{
+			Reason:       reason,
+			BytesRead:    c.BytesRead(),
+			BytesWritten: c.BytesWritten(),
+			Duration:     time.Since(c.connectTime),
+		})
+	})
+	return err
+}
+
+// connected 调用 OnConnect，被拒绝时关闭连接
+func (c *Conn) connected() error {
+	if c.hooks.OnConnect == nil {
+		return nil
+	}
+	if err := c.hooks.OnConnect(c); err != nil {
+		c.CloseWithReason(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) reportError(err error) {
+	c.mu.Lock()
+	c.lastErr = err
+	c.mu.Unlock()
+	if err != io.EOF && c.hooks.OnError != nil {
+		c.hooks.OnError(c, err)
+	}
+}
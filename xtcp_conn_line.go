@@ -0,0 +1,81 @@
+package xtcp
+
+import (
+	"errors"
+	"io"
+)
+
+// 默认一行的最大长度
+const defaultMaxLineLength = 64 * 1024
+
+// ErrLineTooLong 超过最大长度仍然没有读到换行符或分隔符
+var ErrLineTooLong = errors.New("line too long")
+
+var lineDelimiter = []byte{'\n'}
+
+// SetMaxLineLength 设置 RecvLine、RecvTil 读取的最大长度（包含换行符或分隔符），默认 64KB，小于等于 0 表示不限制
+func (c *Conn) SetMaxLineLength(maxLength int) {
+	c.maxLineLength = maxLength
+}
+
+// RecvLine 读取一行，返回的数据不包含结尾的 \n 或 \r\n
+// 读到换行符之前对端关闭时返回已经读取的数据和 io.EOF，超过最大长度时丢弃这一整行（读取到下一个换行符为止）后返回 ErrLineTooLong
+func (c *Conn) RecvLine(retry ...Retry) ([]byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.recvLine(retry...)
+}
+
+// RecvTil 读取到自定义分隔符为止，返回的数据包含分隔符，分隔符区分大小写
+// 读到分隔符之前对端关闭时返回已经读取的数据和 io.EOF，超过最大长度时与 RecvLine 相同，丢弃到下一个分隔符为止后返回 ErrLineTooLong
+func (c *Conn) RecvTil(til []byte, retry ...Retry) ([]byte, error) {
+	if len(til) == 0 {
+		return nil, errors.New("delimiter is empty")
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.recvTil(til, retry...)
+}
+
+// recvLine 读取一行，调用方需要持有 readMu
+func (c *Conn) recvLine(retry ...Retry) ([]byte, error) {
+	data, err := readUntil(c.reader, lineDelimiter, c.maxLineLength, retry...)
+	if err != nil {
+		c.reportError(err)
+		return data, err
+	}
+	return trimLine(data), nil
+}
+
+// recvTil 读取到分隔符为止，调用方需要持有 readMu
+func (c *Conn) recvTil(til []byte, retry ...Retry) ([]byte, error) {
+	data, err := readUntil(c.reader, til, c.maxLineLength, retry...)
+	if err != nil {
+		c.reportError(err)
+	}
+	return data, err
+}
+
+func getMaxLineLength(maxLength ...int) int {
+	if len(maxLength) > 0 {
+		return maxLength[0]
+	}
+	return defaultMaxLineLength
+}
+
+// trimLine 去掉结尾的 \n 或 \r\n
+func trimLine(data []byte) []byte {
+	data = data[:len(data)-1]
+	if len(data) > 0 && data[len(data)-1] == '\r' {
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// unexpectedEOF 在读取到一半时把 io.EOF 转换为 io.ErrUnexpectedEOF
+func unexpectedEOF(data []byte, err error) error {
+	if err == io.EOF && len(data) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
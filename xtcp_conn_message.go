@@ -0,0 +1,98 @@
+package xtcp
+
+import (
+	"context"
+	"io"
+)
+
+// Message 是 Messages 通道中的一条消息，Err 不为 nil 时是通道中的最后一条
+type Message struct {
+	Data []byte
+	Err  error
+}
+
+// Scanner 逐条读取 pkg 消息，用法与 bufio.Scanner 相同
+//
+//	scanner := xtcp.NewScanner(conn)
+//	for scanner.Next() {
+//		handle(scanner.Msg())
+//	}
+//	if err := scanner.Err(); err != nil {
+//		...
+//	}
+type Scanner struct {
+	conn   *Conn
+	option []PkgOption
+	msg    []byte
+	err    error
+}
+
+// Messages 启动一个读取协程循环接收 pkg 消息并写入返回的通道，方便与其他通道一起 select
+// 读取出错时把错误作为最后一条消息发出后关闭通道，ctx 结束时直接关闭通道
+// 通道关闭之前不要在同一个连接上调用其他接收方法
+func (c *Conn) Messages(ctx context.Context, option ...PkgOption) <-chan Message {
+	return messages(ctx, func() ([]byte, error) {
+		return c.RecvPkgCtx(ctx, option...)
+	})
+}
+
+func (c *PoolConn) Messages(ctx context.Context, option ...PkgOption) <-chan Message {
+	return messages(ctx, func() ([]byte, error) {
+		return c.RecvPkgCtx(ctx, option...)
+	})
+}
+
+func messages(ctx context.Context, recv func() ([]byte, error)) <-chan Message {
+	ch := make(chan Message)
+	go func() {
+		defer close(ch)
+		for {
+			data, err := recv()
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			select {
+			case ch <- Message{Data: data, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func NewScanner(conn *Conn, option ...PkgOption) *Scanner {
+	return &Scanner{
+		conn:   conn,
+		option: option,
+	}
+}
+
+// Next 读取下一条消息，出错或者连接关闭时返回 false
+func (s *Scanner) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	s.msg, s.err = s.conn.RecvPkg(s.option...)
+	if s.err != nil {
+		s.msg = nil
+		return false
+	}
+	return true
+}
+
+// Msg 返回 Next 读取的消息
+func (s *Scanner) Msg() []byte {
+	return s.msg
+}
+
+// Err 返回导致 Next 结束的错误，对端正常关闭时返回 nil
+func (s *Scanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
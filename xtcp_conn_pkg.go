@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 )
 
@@ -12,38 +13,65 @@ const (
 	pkgHeaderSizeMax     = 4 // 最大头长度
 )
 
+// PkgStripNone 用于 InitialBytesToStrip，表示 RecvPkg 返回包含包头的整个帧
+const PkgStripNone = -1
+
+// ErrPkgInvalidLength 长度字段的值加上 LengthAdjustment 小于 0，通常意味着数据流已经错位或者 LengthAdjustment 设置错误
+var ErrPkgInvalidLength = errors.New("pkg length is negative")
+
+// PkgOption 长度前缀包的格式，零值为 2 字节大端长度头
+// LengthFieldOffset、LengthAdjustment 与 Netty LengthFieldBasedFrameDecoder 的同名参数含义一致，
+// 用于对接长度字段不在开头、长度包含包头等其他协议
+// InitialBytesToStrip 与 Netty 不同：零值表示去掉整个包头，只返回数据，相当于 Netty 的 LengthFieldOffset + 长度字段字节数；
+// PkgStripNone 相当于 Netty 的 0，正数与 Netty 一致
 type PkgOption struct {
-	HeaderSize  int
-	MaxDataSize int
+	HeaderSize  int // 长度字段的字节数，1-4，Varint 为 true 时忽略
+	MaxDataSize int // 长度字段之后的最大字节数
 	Retry       Retry
+
+	ByteOrder           binary.ByteOrder // 长度字段字节序，只支持 binary.BigEndian（默认）和 binary.LittleEndian
+	Varint              bool             // 长度字段使用 protobuf 风格的 varint 编码
+	LengthFieldOffset   int              // 长度字段之前的字节数，例如魔数
+	HeaderPrefix        []byte           // SendPkg 写在长度字段之前的字节，长度不足 LengthFieldOffset 时补 0
+	LengthAdjustment    int              // 长度字段的值加上 LengthAdjustment 等于长度字段之后的字节数
+	InitialBytesToStrip int              // RecvPkg 从帧开头去掉的字节数，0 表示去掉整个包头，PkgStripNone 表示不去掉
+
+	Frame *PkgFrame // 扩展包头，为 nil 时只有长度字段
+
+	Compressor          Compressor // 压缩算法，需要设置 Frame，RecvPkg 根据标志位自动解压
+	CompressThreshold   int        // 数据达到该长度才压缩，默认 1024
+	MaxDecompressedSize int        // 解压后的最大长度，默认等于 MaxDataSize
+
+	Cipher *PkgCipher // AEAD 加密，需要设置 Frame，每个连接需要单独创建
 }
 
 func (c *Conn) SendPkg(data []byte, option ...PkgOption) error {
 	pkgOption, err := getPkgOption(option...)
 	if err != nil {
-		return nil
-	}
-	length := len(data)
-	if length > pkgOption.MaxDataSize {
-		return fmt.Errorf(`data too long, data size %d`, length)
+		return err
 	}
-	offset := pkgHeaderSizeMax - pkgOption.HeaderSize
-	buffer := make([]byte, pkgHeaderSizeMax+len(data))
-	binary.BigEndian.PutUint32(buffer[0:], uint32(length))
-	copy(buffer[pkgHeaderSizeMax:], data)
-	if pkgOption.Retry.Count > 0 {
-		return c.Send(buffer[offset:], pkgOption.Retry)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.sendPkg(data, &pkgOption)
+}
+
+// sendPkg 封包并发送，调用方需要持有 writeMu，加密的序列号需要与写入顺序一致，封包也在锁内进行
+func (c *Conn) sendPkg(data []byte, pkgOption *PkgOption) error {
+	buffer, err := pkgOption.pack(data)
+	if err != nil {
+		return err
 	}
-	return c.Send(buffer[offset:])
+	return c.send(buffer, pkgOption.Retry)
 }
 
 func (c *Conn) SendPkgWithTimeout(data []byte, timeout time.Duration, option ...PkgOption) error {
-	if err := c.SetSendDeadline(time.Now().Add(timeout)); err != nil {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
 		return err
 	}
-	defer c.SetSendDeadline(time.Time{})
-	err := c.SendPkg(data, option...)
-	return err
+	return c.withDeadline(false, time.Now().Add(timeout), func() error {
+		return c.sendPkg(data, &pkgOption)
+	})
 }
 
 func (c *Conn) SendRecvPkg(data []byte, option ...PkgOption) ([]byte, error) {
@@ -64,45 +92,208 @@ func (c *Conn) SendRecvPkgWithTimeout(data []byte, timeout time.Duration, option
 }
 
 func (c *Conn) RecvPkg(option ...PkgOption) (result []byte, err error) {
-	var buffer []byte
-	var length int
 	pkgOption, err := getPkgOption(option...)
 	if err != nil {
 		return nil, err
 	}
-	buffer, err = c.Recv(pkgOption.HeaderSize, pkgOption.Retry)
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.recvPkg(&pkgOption)
+}
+
+// recvPkg 接收一个包，调用方需要持有 readMu
+func (c *Conn) recvPkg(pkgOption *PkgOption) ([]byte, error) {
+	_, data, err := c.readPkg(pkgOption, func(length int) ([]byte, error) {
+		return c.recv(length, pkgOption.Retry)
+	})
+	return data, err
+}
+
+func (c *Conn) RecvPkgWithTimeout(timeout time.Duration, option ...PkgOption) (data []byte, err error) {
+	pkgOption, err := getPkgOption(option...)
 	if err != nil {
 		return nil, err
 	}
-	switch pkgOption.HeaderSize {
-	case 1:
-		length = int(binary.BigEndian.Uint32([]byte{0, 0, 0, buffer[0]}))
-	case 2:
-		length = int(binary.BigEndian.Uint32([]byte{0, 0, buffer[0], buffer[1]}))
-	case 3:
-		length = int(binary.BigEndian.Uint32([]byte{0, buffer[0], buffer[1], buffer[2]}))
-	case 4:
-		length = int(binary.BigEndian.Uint32([]byte{buffer[0], buffer[1], buffer[2], buffer[3]}))
-	}
-	if length < 0 || length > pkgOption.MaxDataSize {
-		return nil, fmt.Errorf(`data too long, data size is %d`, length)
+	err = c.withDeadline(true, time.Now().Add(timeout), func() error {
+		data, err = c.recvPkg(&pkgOption)
+		return err
+	})
+	return
+}
+
+// pack 在数据前加上包头，包头为 HeaderPrefix + 长度字段，设置了 Frame 时使用扩展包头
+func (o *PkgOption) pack(data []byte) ([]byte, error) {
+	header, payload, err := o.appendHeader(make([]byte, 0, o.headerCap()+len(data)), data)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, payload...), nil
+}
+
+// appendHeader 把 data 的包头追加到 dst，返回追加后的 dst 和需要跟在包头后面发送的数据
+// 设置了压缩或加密时返回的数据与 data 不同
+func (o *PkgOption) appendHeader(dst []byte, data []byte) ([]byte, []byte, error) {
+	if o.Frame != nil {
+		return o.appendFrameHeader(dst, 0, data)
+	}
+	if len(data) > o.MaxDataSize {
+		return nil, nil, fmt.Errorf(`data too long, data size %d`, len(data))
+	}
+	dst = append(dst, o.HeaderPrefix...)
+	for i := len(o.HeaderPrefix); i < o.LengthFieldOffset; i++ {
+		dst = append(dst, 0)
+	}
+	dst, err := o.appendLengthField(dst, len(data)-o.LengthAdjustment)
+	if err != nil {
+		return nil, nil, err
 	}
-	if length == 0 {
-		return nil, nil
+	return dst, data, nil
+}
+
+// headerCap 估算包头的最大长度
+func (o *PkgOption) headerCap() int {
+	size := o.LengthFieldOffset + binary.MaxVarintLen32
+	if o.Frame != nil {
+		size += len(o.Frame.Magic) + 2 + pkgChecksumSize
 	}
-	return c.Recv(length, pkgOption.Retry)
+	return size
 }
 
-func (c *Conn) RecvPkgWithTimeout(timeout time.Duration, option ...PkgOption) (data []byte, err error) {
-	if err := c.SetreceiveDeadline(time.Now().Add(timeout)); err != nil {
+// unpack 通过 read 读取一个完整的包，read 需要读满指定长度
+// 在包边界遇到 io.EOF 时原样返回，包读取到一半时返回 io.ErrUnexpectedEOF
+func (o *PkgOption) unpack(read func(length int) ([]byte, error)) ([]byte, error) {
+	if o.Frame != nil {
+		_, data, err := o.unpackFrame(read)
+		return data, err
+	}
+	var (
+		header []byte
+		field  []byte
+		value  uint64
+		err    error
+	)
+	if o.LengthFieldOffset > 0 {
+		if header, err = read(o.LengthFieldOffset); err != nil {
+			return nil, err
+		}
+	}
+	if field, value, err = o.readLengthField(read); err != nil {
+		if err == io.EOF && header != nil {
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
-	defer c.SetreceiveDeadline(time.Time{})
-	data, err = c.RecvPkg(option...)
+	length := int64(value) + int64(o.LengthAdjustment)
+	if length < 0 {
+		return nil, fmt.Errorf(`%w: length field %d, adjustment %d`, ErrPkgInvalidLength, value, o.LengthAdjustment)
+	}
+	if length > int64(o.MaxDataSize) {
+		return nil, fmt.Errorf(`data too long, data size is %d`, length)
+	}
+	var buffer []byte
+	if length > 0 {
+		buffer, err = read(int(length))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.InitialBytesToStrip == 0 {
+		return buffer, nil
+	}
+	frame := make([]byte, 0, len(header)+len(field)+len(buffer))
+	frame = append(append(append(frame, header...), field...), buffer...)
+	strip := o.InitialBytesToStrip
+	if strip == PkgStripNone {
+		strip = 0
+	}
+	if strip > len(frame) {
+		return nil, fmt.Errorf(`frame size %d is less than bytes to strip %d`, len(frame), strip)
+	}
+	return frame[strip:], nil
+}
+
+// appendLengthField 编码长度字段并追加到 dst
+func (o *PkgOption) appendLengthField(dst []byte, value int) ([]byte, error) {
+	if value < 0 || uint64(value) > o.maxFieldValue() {
+		return nil, fmt.Errorf(`length field value %d overflows`, value)
+	}
+	if o.Varint {
+		var field [binary.MaxVarintLen64]byte
+		return append(dst, field[:binary.PutUvarint(field[:], uint64(value))]...), nil
+	}
+	var field [pkgHeaderSizeMax]byte
+	putUint(field[:o.HeaderSize], o.ByteOrder, uint64(value))
+	return append(dst, field[:o.HeaderSize]...), nil
+}
+
+// readLengthField 读取并解码长度字段
+func (o *PkgOption) readLengthField(read func(length int) ([]byte, error)) ([]byte, uint64, error) {
+	if o.Varint {
+		return readUvarint(read)
+	}
+	field, err := read(o.HeaderSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	return field, getUint(field, o.ByteOrder), nil
+}
+
+// maxFieldValue 返回长度字段能表示的最大值
+func (o *PkgOption) maxFieldValue() uint64 {
+	if o.Varint {
+		return 0x7FFFFFFF
+	}
+	return 1<<(8*uint(o.HeaderSize)) - 1
+}
+
+// readUvarint 逐字节读取 varint 编码的长度字段
+func readUvarint(read func(length int) ([]byte, error)) ([]byte, uint64, error) {
+	field := make([]byte, 0, binary.MaxVarintLen32)
+	for {
+		b, err := read(1)
+		if err != nil {
+			if err == io.EOF && len(field) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, 0, err
+		}
+		field = append(field, b[0])
+		if b[0] < 0x80 {
+			break
+		}
+		if len(field) == binary.MaxVarintLen32 {
+			return nil, 0, errors.New("varint length field overflows")
+		}
+	}
+	value, _ := binary.Uvarint(field)
+	return field, value, nil
+}
+
+func putUint(buffer []byte, order binary.ByteOrder, value uint64) {
+	for i := range buffer {
+		if order == binary.LittleEndian {
+			buffer[i] = byte(value >> (8 * uint(i)))
+		} else {
+			buffer[len(buffer)-1-i] = byte(value >> (8 * uint(i)))
+		}
+	}
+}
+
+func getUint(buffer []byte, order binary.ByteOrder) (value uint64) {
+	for i := range buffer {
+		if order == binary.LittleEndian {
+			value |= uint64(buffer[i]) << (8 * uint(i))
+		} else {
+			value |= uint64(buffer[len(buffer)-1-i]) << (8 * uint(i))
+		}
+	}
 	return
 }
 
-func getPkgOption(option ...PkgOption) (*PkgOption, error) {
+func getPkgOption(option ...PkgOption) (PkgOption, error) {
 	pkgOption := PkgOption{}
 	if len(option) > 0 {
 		pkgOption = option[0]
@@ -111,7 +302,24 @@ func getPkgOption(option ...PkgOption) (*PkgOption, error) {
 		pkgOption.HeaderSize = pkgHeaderSizeDefault
 	}
 	if pkgOption.HeaderSize > pkgHeaderSizeMax {
-		return nil, errors.New("pkgHeaderSize is too big")
+		return PkgOption{}, errors.New("pkgHeaderSize is too big")
+	}
+	if pkgOption.ByteOrder != nil && pkgOption.ByteOrder != binary.BigEndian && pkgOption.ByteOrder != binary.LittleEndian {
+		return PkgOption{}, errors.New("unsupported byte order")
+	}
+	if pkgOption.LengthFieldOffset < 0 || len(pkgOption.HeaderPrefix) > pkgOption.LengthFieldOffset {
+		return PkgOption{}, errors.New("HeaderPrefix is longer than LengthFieldOffset")
+	}
+	if pkgOption.InitialBytesToStrip < PkgStripNone {
+		return PkgOption{}, errors.New("invalid InitialBytesToStrip")
+	}
+	if pkgOption.Frame != nil {
+		if err := pkgOption.Frame.check(&pkgOption); err != nil {
+			return PkgOption{}, err
+		}
+	}
+	if pkgOption.MaxDataSize == 0 && pkgOption.Varint {
+		pkgOption.MaxDataSize = 0x7FFFFFFF
 	}
 	if pkgOption.MaxDataSize == 0 {
 		switch pkgOption.HeaderSize {
@@ -126,7 +334,21 @@ func getPkgOption(option ...PkgOption) (*PkgOption, error) {
 		}
 	}
 	if pkgOption.MaxDataSize > 0x7FFFFFFF {
-		return nil, errors.New("DataSize is too big")
+		return PkgOption{}, errors.New("DataSize is too big")
+	}
+	if pkgOption.Cipher != nil && pkgOption.Frame == nil {
+		return PkgOption{}, errors.New("Cipher requires pkg Frame")
+	}
+	if pkgOption.Compressor != nil {
+		if pkgOption.Frame == nil {
+			return PkgOption{}, errors.New("Compressor requires pkg Frame")
+		}
+		if pkgOption.CompressThreshold == 0 {
+			pkgOption.CompressThreshold = defaultCompressThreshold
+		}
+		if pkgOption.MaxDecompressedSize == 0 {
+			pkgOption.MaxDecompressedSize = pkgOption.MaxDataSize
+		}
 	}
-	return &pkgOption, nil
+	return pkgOption, nil
 }
@@ -0,0 +1,201 @@
+package xtcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	PkgChecksumNone   = 0
+	PkgChecksumCRC32  = 1 // IEEE
+	PkgChecksumCRC32C = 2 // Castagnoli
+
+	pkgChecksumSize = 4
+	pkgFlagReserved = 0x0F // 低 4 位标志位保留给 xtcp 使用
+)
+
+// PkgFrame 扩展包头，格式为 魔数 + 版本(1 字节) + 标志位(1 字节) + 长度字段 + 校验和(4 字节，可选) + 数据
+// 长度字段的编码仍由 PkgOption 的 HeaderSize、ByteOrder、Varint 决定，值为数据的长度
+type PkgFrame struct {
+	Magic    []byte
+	Version  byte
+	Flags    byte // 发送时写入的标志位，只能使用高 4 位
+	Checksum int  // 数据的校验方式，PkgChecksumNone、PkgChecksumCRC32 或 PkgChecksumCRC32C
+}
+
+var (
+	// ErrPkgBadMagic 魔数不匹配，通常意味着数据流已经错位，应该关闭连接
+	ErrPkgBadMagic = errors.New("pkg magic mismatch")
+	// ErrPkgVersion 对端的协议版本与 PkgFrame.Version 不一致
+	ErrPkgVersion = errors.New("pkg version mismatch")
+	// ErrPkgChecksum 数据校验失败
+	ErrPkgChecksum = errors.New("pkg checksum mismatch")
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// RecvPkgFlags 接收一个扩展包头的包，同时返回包头中的标志位
+func (c *Conn) RecvPkgFlags(option ...PkgOption) ([]byte, byte, error) {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if pkgOption.Frame == nil {
+		return nil, 0, errors.New("pkg frame is not configured")
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	flags, data, err := c.readPkg(&pkgOption, func(length int) ([]byte, error) {
+		return c.recv(length, pkgOption.Retry)
+	})
+	return data, flags, err
+}
+
+func (f *PkgFrame) check(o *PkgOption) error {
+	if o.LengthFieldOffset != 0 || len(o.HeaderPrefix) != 0 || o.LengthAdjustment != 0 || o.InitialBytesToStrip != 0 {
+		return errors.New("pkg frame can not be used with custom length field layout")
+	}
+	if f.Flags&pkgFlagReserved != 0 {
+		return fmt.Errorf(`pkg flags 0x%02X use reserved bits`, f.Flags)
+	}
+	if f.Checksum < PkgChecksumNone || f.Checksum > PkgChecksumCRC32C {
+		return fmt.Errorf(`unknown pkg checksum %d`, f.Checksum)
+	}
+	return nil
+}
+
+func (f *PkgFrame) sum(data []byte) uint32 {
+	if f.Checksum == PkgChecksumCRC32C {
+		return crc32.Checksum(data, crc32cTable)
+	}
+	return crc32.ChecksumIEEE(data)
+}
+
+// packFrame 使用扩展包头封包，flags 为 xtcp 内部使用的标志位
+func (o *PkgOption) packFrame(flags byte, data []byte) ([]byte, error) {
+	header, payload, err := o.appendFrameHeader(make([]byte, 0, o.headerCap()+len(data)), flags, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, payload...), nil
+}
+
+// appendFrameHeader 压缩、加密数据后把扩展包头追加到 dst，返回追加后的 dst 和处理后的数据
+func (o *PkgOption) appendFrameHeader(dst []byte, flags byte, data []byte) ([]byte, []byte, error) {
+	flags, data, err := o.compressFrame(flags, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return o.sealFrame(dst, flags, data)
+}
+
+// compressFrame 检查长度并压缩数据，返回加上 Frame.Flags 和压缩标志位的 flags
+func (o *PkgOption) compressFrame(flags byte, data []byte) (byte, []byte, error) {
+	if len(data) > o.MaxDataSize {
+		return 0, nil, fmt.Errorf(`data too long, data size %d`, len(data))
+	}
+	data, compressed, err := o.compress(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return flags | o.Frame.Flags | compressed, data, nil
+}
+
+// checkFrameLength 检查压缩后的数据加密后能否写入长度字段，在加密之前调用，失败时不会推进加密的序列号
+func (o *PkgOption) checkFrameLength(data []byte) error {
+	size := len(data)
+	if o.Cipher != nil {
+		size += o.Cipher.overhead()
+	}
+	if uint64(size) > o.maxFieldValue() {
+		return fmt.Errorf(`length field value %d overflows`, size)
+	}
+	return nil
+}
+
+// sealFrame 加密 compressFrame 处理后的数据，把扩展包头追加到 dst
+func (o *PkgOption) sealFrame(dst []byte, flags byte, data []byte) ([]byte, []byte, error) {
+	f := o.Frame
+	err := o.checkFrameLength(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if o.Cipher != nil {
+		flags |= PkgFlagEncrypted
+		if data, err = o.Cipher.seal(flags, data); err != nil {
+			return nil, nil, err
+		}
+	}
+	dst = append(dst, f.Magic...)
+	dst = append(dst, f.Version, flags)
+	if dst, err = o.appendLengthField(dst, len(data)); err != nil {
+		return nil, nil, err
+	}
+	if f.Checksum != PkgChecksumNone {
+		var checksum [pkgChecksumSize]byte
+		binary.BigEndian.PutUint32(checksum[:], f.sum(data))
+		dst = append(dst, checksum[:]...)
+	}
+	return dst, data, nil
+}
+
+// unpackFrame 读取扩展包头的包并校验魔数、版本和校验和
+func (o *PkgOption) unpackFrame(read func(length int) ([]byte, error)) (byte, []byte, error) {
+	f := o.Frame
+	header, err := read(len(f.Magic) + 2)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !bytes.Equal(header[:len(f.Magic)], f.Magic) {
+		return 0, nil, fmt.Errorf(`%w: got 0x%X`, ErrPkgBadMagic, header[:len(f.Magic)])
+	}
+	if version := header[len(f.Magic)]; version != f.Version {
+		return 0, nil, fmt.Errorf(`%w: got %d, want %d`, ErrPkgVersion, version, f.Version)
+	}
+	flags := header[len(f.Magic)+1]
+	maxSize := o.MaxDataSize
+	if o.Cipher != nil {
+		maxSize += o.Cipher.overhead()
+	}
+	_, value, err := o.readLengthField(read)
+	if err == nil && value > uint64(maxSize) {
+		err = fmt.Errorf(`data too long, data size is %d`, value)
+	}
+	var checksum []byte
+	if err == nil && f.Checksum != PkgChecksumNone {
+		checksum, err = read(pkgChecksumSize)
+	}
+	var data []byte
+	if err == nil && value > 0 {
+		data, err = read(int(value))
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	if checksum != nil {
+		if sum := f.sum(data); sum != binary.BigEndian.Uint32(checksum) {
+			return 0, nil, fmt.Errorf(`%w: got 0x%08X, want 0x%08X`, ErrPkgChecksum, sum, binary.BigEndian.Uint32(checksum))
+		}
+	}
+	if flags&PkgFlagEncrypted != 0 {
+		if o.Cipher == nil {
+			return 0, nil, errors.New("received encrypted pkg but Cipher is not configured")
+		}
+		if data, err = o.Cipher.open(flags, data); err != nil {
+			return 0, nil, err
+		}
+	} else if o.Cipher != nil {
+		return 0, nil, fmt.Errorf(`%w: pkg is not encrypted`, ErrPkgDecrypt)
+	}
+	if data, err = o.decompress(flags, data); err != nil {
+		return 0, nil, err
+	}
+	return flags, data, nil
+}
@@ -0,0 +1,61 @@
+package xtcp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ID 返回连接在进程内唯一的编号
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
+// ConnectTime 返回连接建立的时间，服务端为 accept 时间，客户端为拨号完成时间
+func (c *Conn) ConnectTime() time.Time {
+	return c.connectTime
+}
+
+// LastActive 返回最后一次成功读写数据的时间
+func (c *Conn) LastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActive))
+}
+
+// Principal 返回认证通过后设置的身份，未认证时为 nil
+func (c *Conn) Principal() interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.principal
+}
+
+func (c *Conn) SetPrincipal(principal interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.principal = principal
+}
+
+// Set 设置连接级别的属性，在连接的生命周期内可供中间件和 handler 共享
+func (c *Conn) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.attributes == nil {
+		c.attributes = make(map[string]interface{})
+	}
+	c.attributes[key] = value
+}
+
+// Get 获取连接属性，不存在时返回 nil
+func (c *Conn) Get(key string) interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.attributes[key]
+}
+
+func (c *Conn) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.attributes, key)
+}
+
+func (c *Conn) touch() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
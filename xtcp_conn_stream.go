@@ -0,0 +1,119 @@
+package xtcp
+
+import (
+	"errors"
+	"io"
+)
+
+// 流式传输时每个包的默认大小
+const defaultStreamChunkSize = 32 * 1024
+
+// pkgStreamReader 按包读取流数据，读到空包时结束
+type pkgStreamReader struct {
+	recv   func() ([]byte, error)
+	buffer []byte
+	err    error
+}
+
+// SendStream 把 reader 中的数据切分为多个包发送，最后发送一个空包作为结束标记，内存占用与数据总大小无关
+// 发送期间一直持有写锁，其他协程的发送会等待到流结束，不会与流的包交错
+// reader 出错时不会发送结束标记，对端会在连接关闭后收到 io.ErrUnexpectedEOF，此时应该关闭连接
+// 空包需要解析为空数据才能作为结束标记，InitialBytesToStrip 和 LengthFieldOffset 不为 0 时返回错误
+func (c *Conn) SendStream(reader io.Reader, option ...PkgOption) error {
+	pkgOption, err := getStreamOption(option...)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.sendStream(reader, &pkgOption)
+}
+
+// RecvStream 返回读取 SendStream 发送的数据的 io.Reader，读完结束标记后返回 io.EOF
+// 在读完之前不能在同一个连接上调用其他接收方法，包格式的限制与 SendStream 相同
+func (c *Conn) RecvStream(option ...PkgOption) io.Reader {
+	pkgOption, err := getStreamOption(option...)
+	if err != nil {
+		return &pkgStreamReader{err: err}
+	}
+	return &pkgStreamReader{
+		recv: func() ([]byte, error) {
+			return c.RecvPkg(pkgOption)
+		},
+	}
+}
+
+func (c *PoolConn) SendStream(reader io.Reader, option ...PkgOption) error {
+	return c.setStatus(c.Conn.SendStream(reader, option...))
+}
+
+func (c *PoolConn) RecvStream(option ...PkgOption) io.Reader {
+	pkgOption, err := getStreamOption(option...)
+	if err != nil {
+		return &pkgStreamReader{err: err}
+	}
+	return &pkgStreamReader{
+		recv: func() ([]byte, error) {
+			return c.RecvPkg(pkgOption)
+		},
+	}
+}
+
+// getStreamOption 检查流式传输的包格式，结束标记的空包在对端需要解析为空数据
+func getStreamOption(option ...PkgOption) (PkgOption, error) {
+	pkgOption, err := getPkgOption(option...)
+	if err != nil {
+		return PkgOption{}, err
+	}
+	if pkgOption.InitialBytesToStrip != 0 || pkgOption.LengthFieldOffset != 0 {
+		return PkgOption{}, errors.New("stream requires InitialBytesToStrip and LengthFieldOffset to be 0")
+	}
+	return pkgOption, nil
+}
+
+// sendStream 发送流的所有包和结束标记，调用方需要持有 writeMu
+func (c *Conn) sendStream(reader io.Reader, pkgOption *PkgOption) error {
+	size := defaultStreamChunkSize
+	if size > pkgOption.MaxDataSize {
+		size = pkgOption.MaxDataSize
+	}
+	buffer := make([]byte, size)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if e := c.sendPkg(buffer[:n], pkgOption); e != nil {
+				return e
+			}
+		}
+		if err == io.EOF {
+			return c.sendPkg(nil, pkgOption)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *pkgStreamReader) Read(p []byte) (int, error) {
+	for len(r.buffer) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		data, err := r.recv()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			r.err = err
+			return 0, err
+		}
+		if len(data) == 0 {
+			r.err = io.EOF
+			return 0, io.EOF
+		}
+		r.buffer = data
+	}
+	n := copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+	return n, nil
+}
@@ -1,11 +1,25 @@
 package xtcp_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"github.com/motai3/xtcp"
 	"github.com/motai3/xtcp/container/xlist"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"math/big"
+	"net"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -147,3 +161,844 @@ func Test_Package_Option(t *testing.T) {
 	//	assert.Equal(t, result, data)
 	//})
 }
+
+func Test_Conn_Session(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvPkg()
+			if err != nil {
+				break
+			}
+			if conn.Principal() == nil {
+				conn.SetPrincipal(string(data))
+			}
+			conn.SendPkg([]byte(conn.Principal().(string)))
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	t.Run("Attributes", func(t *testing.T) {
+		conn.Set("user", "alice")
+		assert.Equal(t, "alice", conn.Get("user"))
+		conn.Delete("user")
+		assert.Nil(t, conn.Get("user"))
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		other, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+		assert.NoError(t, err)
+		defer other.Close()
+		assert.NotEqual(t, conn.ID(), other.ID())
+
+		before := conn.LastActive()
+		time.Sleep(10 * time.Millisecond)
+		result, err := conn.SendRecvPkg([]byte("alice"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("alice"), result)
+		assert.True(t, conn.LastActive().After(before))
+		assert.False(t, conn.ConnectTime().After(before))
+
+		result, err = conn.SendRecvPkg([]byte("bob"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("alice"), result)
+	})
+}
+
+func Test_Conn_Hooks(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	closed := make(chan xtcp.CloseInfo, 1)
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvPkg()
+			if err != nil {
+				break
+			}
+			conn.SendPkg(data)
+		}
+	})
+	server.SetOnConnect(func(c *xtcp.Conn) error {
+		if c.ID()%2 == 0 {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+	server.SetOnClose(func(c *xtcp.Conn, info xtcp.CloseInfo) {
+		closed <- info
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	dialed := 0
+	xtcp.SetClientHooks(xtcp.ConnHooks{
+		OnConnect: func(c *xtcp.Conn) error {
+			dialed++
+			return nil
+		},
+	})
+	defer xtcp.SetClientHooks(xtcp.ConnHooks{})
+
+	var accepted *xtcp.Conn
+	for accepted == nil {
+		conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+		assert.NoError(t, err)
+		if _, err = conn.SendRecvPkgWithTimeout([]byte("hello"), time.Second); err == nil {
+			accepted = conn
+		} else {
+			info := <-closed
+			assert.EqualError(t, info.Reason, "rejected")
+			conn.Close()
+		}
+	}
+	assert.True(t, dialed > 0)
+
+	var clientInfo xtcp.CloseInfo
+	accepted.SetOnClose(func(c *xtcp.Conn, info xtcp.CloseInfo) {
+		clientInfo = info
+	})
+	accepted.Close()
+	assert.Equal(t, int64(7), clientInfo.BytesWritten)
+	assert.Equal(t, int64(7), clientInfo.BytesRead)
+	assert.NoError(t, clientInfo.Reason)
+
+	info := <-closed
+	assert.Equal(t, io.EOF, info.Reason)
+	assert.Equal(t, int64(7), info.BytesRead)
+	assert.Equal(t, int64(7), info.BytesWritten)
+
+	// 读写出错时调用 OnError，关闭原因为最后一次读写错误
+	client, peer := net.Pipe()
+	conn := xtcp.NewConnByNetConn(client)
+	var hookErrs []error
+	conn.SetOnError(func(c *xtcp.Conn, err error) {
+		hookErrs = append(hookErrs, err)
+	})
+	conn.SetOnClose(func(c *xtcp.Conn, info xtcp.CloseInfo) {
+		clientInfo = info
+	})
+	_, readErr := conn.RecvPkgWithTimeout(10 * time.Millisecond)
+	assert.Error(t, readErr)
+	peer.Close()
+	writeErr := conn.SendPkg([]byte("hello"))
+	assert.Error(t, writeErr)
+	assert.Equal(t, []error{readErr, writeErr}, hookErrs)
+	conn.Close()
+	assert.Equal(t, writeErr, clientInfo.Reason)
+}
+
+func Test_Conn_HalfClose(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		var request []byte
+		for {
+			data, err := conn.RecvPkg()
+			if err == io.EOF && conn.PeerClosedWrite() {
+				break
+			}
+			if err != nil {
+				return
+			}
+			request = append(request, data...)
+		}
+		conn.SendPkg(request)
+		conn.CloseWrite()
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+	// CloseWrite 先写入缓冲中的数据
+	conn.EnableWriteBuffer(4096, 0)
+	assert.NoError(t, conn.SendPkg([]byte("hello ")))
+	assert.NoError(t, conn.SendPkg([]byte("world")))
+	assert.NoError(t, conn.CloseWrite())
+
+	result, err := conn.RecvPkg()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello world"), result)
+	_, err = conn.RecvPkg()
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, conn.PeerClosedWrite())
+}
+
+// newTestTLSConfig 生成自签名证书，返回服务端使用的 tls.Config
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
+
+func Test_Conn_HalfCloseTLS(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServerTLS(fmt.Sprintf(`:%d`, p), newTestTLSConfig(t), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		var request []byte
+		for {
+			data, err := conn.RecvPkg()
+			if err == io.EOF && conn.PeerClosedWrite() {
+				break
+			}
+			if err != nil {
+				return
+			}
+			request = append(request, data...)
+		}
+		// 收到 close_notify 之后仍然可以发送
+		conn.SendPkg(request)
+		conn.CloseWrite()
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConnTLS(fmt.Sprintf("127.0.0.1:%d", p), &tls.Config{InsecureSkipVerify: true})
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, conn.SendPkg([]byte("hello ")))
+	assert.NoError(t, conn.SendPkg([]byte("world")))
+	assert.NoError(t, conn.CloseWrite())
+	assert.Equal(t, xtcp.ErrHalfCloseUnsupported, conn.CloseRead())
+
+	result, err := conn.RecvPkg()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello world"), result)
+	_, err = conn.RecvPkg()
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, conn.PeerClosedWrite())
+}
+
+func Test_Conn_Context(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvPkg()
+			if err != nil {
+				break
+			}
+			if string(data) == "slow" {
+				continue
+			}
+			conn.SendPkg(data)
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		result, err := conn.SendRecvPkgCtx(ctx, []byte("slow"))
+		assert.Equal(t, context.Canceled, err)
+		assert.Empty(t, result)
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := conn.SendRecvPkgCtx(ctx, []byte("slow"))
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		result, err := conn.SendRecvPkgCtx(ctx, []byte("fast"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("fast"), result)
+	})
+
+	t.Run("Line", func(t *testing.T) {
+		client, server := net.Pipe()
+		conn := xtcp.NewConnByNetConn(client)
+		defer conn.Close()
+		peer := xtcp.NewConnByNetConn(server)
+		defer peer.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := conn.RecvLineCtx(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		go func() {
+			if data, err := peer.Recv(4); err == nil {
+				peer.Send(append(data, "\nab$"...))
+			}
+		}()
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		// 超时之后连接仍然可用
+		result, err := conn.SendRecvCtx(ctx, []byte("ping"), 2)
+		assert.NoError(t, err)
+		assert.Equal(t, "pi", string(result))
+		result, err = conn.RecvLineCtx(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "ng", string(result))
+		result, err = conn.RecvTilCtx(ctx, []byte("$"))
+		assert.NoError(t, err)
+		assert.Equal(t, "ab$", string(result))
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		client, server := net.Pipe()
+		conn := xtcp.NewConnByNetConn(client)
+		defer conn.Close()
+		defer server.Close()
+
+		// ctx 结束导致的超时不再重试
+		for _, retry := range []xtcp.Retry{{Count: -1}, {Count: 20}} {
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			_, err := conn.RecvCtx(ctx, 4, retry)
+			cancel()
+			assert.Equal(t, context.DeadlineExceeded, err)
+			assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+			start = time.Now()
+			ctx, cancel = context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			_, err = conn.RecvCtx(ctx, 4, retry)
+			assert.Equal(t, context.Canceled, err)
+			assert.True(t, time.Since(start) < 500*time.Millisecond)
+		}
+	})
+}
+
+func Test_Conn_Stream(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			hash := sha256.New()
+			if _, err := io.Copy(hash, conn.RecvStream()); err != nil {
+				break
+			}
+			conn.SendPkg(hash.Sum(nil))
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	for _, size := range []int{0, 100, 5 * 1024 * 1024} {
+		data := make([]byte, size)
+		rand.Read(data)
+		assert.NoError(t, conn.SendStream(bytes.NewReader(data)))
+		result, err := conn.RecvPkg()
+		assert.NoError(t, err)
+		sum := sha256.Sum256(data)
+		assert.Equal(t, sum[:], result)
+	}
+
+	// 空包不能解析为空数据的格式无法发送结束标记
+	for _, option := range []xtcp.PkgOption{{InitialBytesToStrip: xtcp.PkgStripNone}, {LengthFieldOffset: 2}} {
+		assert.Error(t, conn.SendStream(bytes.NewReader(nil), option))
+		_, err := conn.RecvStream(option).Read(make([]byte, 1))
+		assert.Error(t, err)
+	}
+}
+
+func Test_Conn_StreamExclusive(t *testing.T) {
+	client, server := net.Pipe()
+	conn := xtcp.NewConnByNetConn(client)
+	defer conn.Close()
+	peer := xtcp.NewConnByNetConn(server)
+	defer peer.Close()
+
+	// 流发送到一半时其他协程的 SendPkg 等待流结束
+	reader, writer := io.Pipe()
+	go func() {
+		writer.Write([]byte("hello "))
+		go conn.SendPkg([]byte("other"))
+		time.Sleep(20 * time.Millisecond)
+		writer.Write([]byte("world"))
+		writer.Close()
+	}()
+	go conn.SendStream(reader)
+
+	data, err := io.ReadAll(peer.RecvStream())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	data, err = peer.RecvPkgWithTimeout(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", string(data))
+}
+
+// repeatConn 循环返回同一个包的数据，用于测试接收路径的内存分配
+type repeatConn struct {
+	net.Conn
+	frame  []byte
+	offset int
+}
+
+func (c *repeatConn) Read(p []byte) (int, error) {
+	n := copy(p, c.frame[c.offset:])
+	c.offset = (c.offset + n) % len(c.frame)
+	return n, nil
+}
+
+func newRepeatConn(b testing.TB, size int) *xtcp.Conn {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	frame, err := newPkgCodec(b).Encode(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return xtcp.NewConnByNetConn(&repeatConn{frame: frame})
+}
+
+func Test_Conn_RecvPkgInto(t *testing.T) {
+	conn := newRepeatConn(t, 1000)
+	expect, err := conn.RecvPkg()
+	assert.NoError(t, err)
+
+	buffer := make([]byte, 2048)
+	data, err := conn.RecvPkgInto(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, expect, data)
+	assert.Equal(t, &buffer[2], &data[0])
+
+	data, err = conn.RecvPkgInto(make([]byte, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, expect, data)
+
+	pooled, err := conn.RecvPkgBuffer()
+	assert.NoError(t, err)
+	assert.Equal(t, expect, pooled.Bytes())
+	pooled.Release()
+	// 归还后不再持有数据，重复调用不会把已经被复用的缓冲再次放回缓冲池
+	assert.Nil(t, pooled.Bytes())
+	pooled.Release()
+}
+
+func Benchmark_Conn_RecvPkg(b *testing.B) {
+	conn := newRepeatConn(b, 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.RecvPkg(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_Conn_RecvPkgInto(b *testing.B) {
+	conn := newRepeatConn(b, 1024)
+	buffer := make([]byte, 2048)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.RecvPkgInto(buffer); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_Conn_RecvPkgBuffer(b *testing.B) {
+	conn := newRepeatConn(b, 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buffer, err := conn.RecvPkgBuffer()
+		if err != nil {
+			b.Fatal(err)
+		}
+		buffer.Release()
+	}
+}
+
+func Test_Conn_SendPkgBatch(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvPkg()
+			if err != nil {
+				break
+			}
+			conn.SendPkg(data)
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	datas := make([][]byte, 100)
+	for i := range datas {
+		datas[i] = []byte(strconv.Itoa(i))
+	}
+	datas[50] = nil
+	assert.NoError(t, conn.SendPkgBatch(datas))
+	assert.Equal(t, int64(2*100+188), conn.BytesWritten())
+	for i := range datas {
+		result, err := conn.RecvPkg()
+		assert.NoError(t, err)
+		assert.Equal(t, string(datas[i]), string(result))
+	}
+
+	assert.Error(t, conn.SendPkgBatch([][]byte{make([]byte, 0x10000)}))
+}
+
+func Test_Conn_WriteBuffer(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvPkg()
+			if err != nil {
+				break
+			}
+			conn.SendPkg(data)
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.EnableWriteBuffer(4096, 50*time.Millisecond)
+
+	t.Run("Interval", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, conn.SendPkg([]byte("0123456789")))
+		}
+		assert.Equal(t, int64(0), conn.BytesWritten())
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, int64(120), conn.BytesWritten())
+		for i := 0; i < 10; i++ {
+			result, err := conn.RecvPkg()
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("0123456789"), result)
+		}
+	})
+
+	t.Run("FlushBeforeRecv", func(t *testing.T) {
+		result, err := conn.SendRecvPkgWithTimeout([]byte("ping"), 20*time.Millisecond)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("ping"), result)
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		written := conn.BytesWritten()
+		assert.NoError(t, conn.SendPkg(make([]byte, 5000)))
+		assert.True(t, conn.BytesWritten() > written)
+		assert.NoError(t, conn.Flush())
+		_, err := conn.RecvPkg()
+		assert.NoError(t, err)
+	})
+}
+
+func Test_Conn_WriteBufferBlocked(t *testing.T) {
+	// 对端不读取，写入连接的协程一直阻塞
+	client, peer := net.Pipe()
+	defer peer.Close()
+	conn := xtcp.NewConnByNetConn(client)
+	conn.EnableWriteBuffer(16, 0)
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- conn.SendPkg(make([]byte, 64))
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// 读取不等待阻塞的写入
+	start := time.Now()
+	_, err := conn.RecvWithTimeout(1, 50*time.Millisecond)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// Close 不等待阻塞的写入，阻塞的写入随之返回错误
+	start = time.Now()
+	assert.NoError(t, conn.Close())
+	assert.True(t, time.Since(start) < time.Second)
+	select {
+	case err = <-sent:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("send is still blocked after close")
+	}
+}
+
+func Test_Conn_Concurrent(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvPkg()
+			if err != nil {
+				break
+			}
+			conn.SendPkg(data)
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, buffered := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Buffered=%v", buffered), func(t *testing.T) {
+			conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+			assert.NoError(t, err)
+			defer conn.Close()
+			if buffered {
+				conn.EnableWriteBuffer(1024, time.Millisecond)
+			}
+
+			const writers, count = 8, 200
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					data := bytes.Repeat([]byte{byte('a' + i)}, 1000+i)
+					for j := 0; j < count; j++ {
+						if j%2 == 0 {
+							assert.NoError(t, conn.SendPkg(data))
+						} else {
+							assert.NoError(t, conn.SendPkgBatch([][]byte{data}))
+						}
+					}
+				}(i)
+			}
+
+			received := make(chan []byte, writers*count)
+			var readers sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				readers.Add(1)
+				go func() {
+					defer readers.Done()
+					for {
+						data, err := conn.RecvPkg()
+						if err != nil {
+							return
+						}
+						received <- data
+					}
+				}()
+			}
+			wg.Wait()
+			for deadline := time.Now().Add(5 * time.Second); len(received) < writers*count && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+			conn.Close()
+			readers.Wait()
+			close(received)
+
+			counts := make(map[byte]int)
+			for data := range received {
+				assert.Equal(t, bytes.Repeat(data[:1], len(data)), data)
+				assert.Equal(t, 1000+int(data[0]-'a'), len(data))
+				counts[data[0]]++
+			}
+			for i := 0; i < writers; i++ {
+				assert.Equal(t, count, counts[byte('a'+i)])
+			}
+		})
+	}
+}
+
+func Test_Conn_ConcurrentDeadline(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		// 先不读取，让写入阻塞在连接上
+		time.Sleep(300 * time.Millisecond)
+		io.Copy(io.Discard, conn)
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// 带超时和 ctx 的写入只影响自己，不会打断其他 goroutine 正在进行的写入
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			conn.SendPkgCtx(ctx, []byte("ctx"))
+			cancel()
+			conn.SendPkgWithTimeout([]byte("timeout"), time.Millisecond)
+			conn.SendWithTimeout([]byte("raw"), time.Millisecond)
+		}
+	}()
+	data := make([]byte, 60*1024)
+	for i := 0; i < 300; i++ {
+		assert.NoError(t, conn.SendPkg(data))
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func Test_Conn_RecvLine(t *testing.T) {
+	client, server := net.Pipe()
+	conn := xtcp.NewConnByNetConn(client)
+	defer conn.Close()
+	go func() {
+		server.Write([]byte("hello\r\nworld\nkey=ABCvalue=abc"))
+		server.Write(bytes.Repeat([]byte("x"), 100))
+		server.Write([]byte("\nnext\n"))
+		// 超过读缓冲的超长行，剩余部分不能被当作新的一行
+		server.Write(bytes.Repeat([]byte("y"), 6000))
+		server.Write([]byte("\nafter\n"))
+		// 多字节分隔符跨越两次写入
+		server.Write(append(bytes.Repeat([]byte("z"), 100), 'a', 'b'))
+		server.Write([]byte("cdone=abc"))
+		server.Write([]byte("tail"))
+		server.Close()
+	}()
+
+	line, err := conn.RecvLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(line))
+	line, err = conn.RecvLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(line))
+
+	data, err := conn.RecvTil([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, "key=ABCvalue=abc", string(data))
+
+	conn.SetMaxLineLength(50)
+	_, err = conn.RecvLine()
+	assert.Equal(t, xtcp.ErrLineTooLong, err)
+	line, err = conn.RecvLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "next", string(line))
+
+	_, err = conn.RecvLine()
+	assert.Equal(t, xtcp.ErrLineTooLong, err)
+	line, err = conn.RecvLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "after", string(line))
+
+	_, err = conn.RecvTil([]byte("abc"))
+	assert.Equal(t, xtcp.ErrLineTooLong, err)
+	data, err = conn.RecvTil([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, "done=abc", string(data))
+
+	line, err = conn.RecvLine()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "tail", string(line))
+}
+
+func Test_Conn_Messages(t *testing.T) {
+	client, server := net.Pipe()
+	conn := xtcp.NewConnByNetConn(client)
+	defer conn.Close()
+	peer := xtcp.NewConnByNetConn(server)
+	go func() {
+		peer.SendPkg([]byte("a"))
+		peer.SendPkg([]byte("b"))
+		peer.Close()
+	}()
+
+	t.Run("Channel", func(t *testing.T) {
+		var result []string
+		for msg := range conn.Messages(context.Background()) {
+			if msg.Err != nil {
+				assert.Equal(t, io.EOF, msg.Err)
+				break
+			}
+			result = append(result, string(msg.Data))
+		}
+		assert.Equal(t, []string{"a", "b"}, result)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer server.Close()
+		conn := xtcp.NewConnByNetConn(client)
+		defer conn.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := conn.Messages(ctx)
+		cancel()
+		select {
+		case _, ok := <-ch:
+			assert.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("channel is not closed after cancel")
+		}
+	})
+
+	t.Run("Scanner", func(t *testing.T) {
+		client, server := net.Pipe()
+		conn := xtcp.NewConnByNetConn(client)
+		defer conn.Close()
+		peer := xtcp.NewConnByNetConn(server)
+		go func() {
+			peer.SendPkg([]byte("x"))
+			peer.SendPkg([]byte("y"))
+			peer.Close()
+		}()
+		scanner := xtcp.NewScanner(conn)
+		var result []string
+		for scanner.Next() {
+			result = append(result, string(scanner.Msg()))
+		}
+		assert.NoError(t, scanner.Err())
+		assert.Equal(t, []string{"x", "y"}, result)
+	})
+}
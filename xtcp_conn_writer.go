@@ -0,0 +1,184 @@
+package xtcp
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writeBuffer 连接的写缓冲，缓冲满、到达刷新间隔、调用 Flush 或者开始阻塞读取时写入连接
+// mu 只保护缓冲的状态，写入连接时不持有 mu，对端不读取时 Close 和读取不会被卡在 mu 上
+type writeBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond // 写入连接结束时通知等待的协程
+	data     []byte     // 还没有写入连接的数据
+	spare    []byte     // 写入连接后回收的缓冲，与 data 交替使用
+	size     int
+	err      error // 写入连接失败后的错误，之后的写入都返回该错误
+	flushing bool  // 有协程正在写入连接，它会写完期间追加到 data 的数据
+	interval time.Duration
+	timer    *time.Timer
+	pending  bool // 是否已经安排了定时刷新
+}
+
+// bufferedWriter 把 net.Buffers 写入写缓冲
+type bufferedWriter struct {
+	c *Conn
+}
+
+// Close 时写入缓冲中剩余数据的超时，对端不读取时不会让 Close 一直等待
+const closeFlushTimeout = time.Second
+
+// EnableWriteBuffer 开启写缓冲，把多次小的 Send/SendPkg 合并后写入连接
+// size 为缓冲大小，缓冲满时立即写入；flushInterval 大于 0 时，数据在缓冲中最多停留该时间
+// 需要在连接被并发使用之前调用，Close 时会尽量写入缓冲中剩余的数据，最多等待 closeFlushTimeout
+func (c *Conn) EnableWriteBuffer(size int, flushInterval time.Duration) {
+	b := &writeBuffer{
+		data:     make([]byte, 0, size),
+		size:     size,
+		interval: flushInterval,
+	}
+	b.cond = sync.NewCond(&b.mu)
+	c.writeBuffer = b
+}
+
+// Flush 把写缓冲中的数据写入连接，其他协程正在写入时等待它写完，未开启写缓冲时不做任何操作
+func (c *Conn) Flush() error {
+	b := c.writeBuffer
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	for b.flushing {
+		b.cond.Wait()
+	}
+	return c.flushLocked()
+}
+
+// flushLocked 在持有 mu 且没有其他协程写入时调用，释放 mu 后写入连接，返回时已经释放 mu
+func (c *Conn) flushLocked() error {
+	b := c.writeBuffer
+	if b.timer != nil && b.pending {
+		b.timer.Stop()
+		b.pending = false
+	}
+	err := b.err
+	if err != nil || len(b.data) == 0 {
+		b.mu.Unlock()
+		return err
+	}
+	b.flushing = true
+	for len(b.data) > 0 && b.err == nil {
+		data := b.data
+		b.data = b.spare[:0]
+		b.mu.Unlock()
+		_, err = connWriter{c}.Write(data)
+		b.mu.Lock()
+		b.spare = data[:0]
+		if err != nil {
+			b.err = err
+			b.data = b.data[:0]
+		}
+	}
+	b.flushing = false
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	if err != nil {
+		c.reportError(err)
+	}
+	return err
+}
+
+// flushAsync 在单独的协程中写入缓冲中的数据，不等待写入完成，用于阻塞读取之前发出请求
+func (c *Conn) flushAsync() {
+	b := c.writeBuffer
+	b.mu.Lock()
+	if b.flushing || b.err != nil || len(b.data) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.flushing = true
+	b.mu.Unlock()
+	go func() {
+		b.mu.Lock()
+		b.flushing = false
+		b.cond.Broadcast()
+		c.flushLocked()
+	}()
+}
+
+// flushOnClose 在关闭连接之前尽量写入缓冲中的数据，其他协程正在写入时不等待，直接关闭连接让它返回
+func (c *Conn) flushOnClose() {
+	b := c.writeBuffer
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if b.flushing || b.err != nil || len(b.data) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	c.Conn.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+	c.flushLocked()
+}
+
+// write 写入数据，开启写缓冲时写入缓冲，缓冲满时写入连接
+func (c *Conn) write(data []byte) (int, error) {
+	b := c.writeBuffer
+	if b == nil {
+		return connWriter{c}.Write(data)
+	}
+	b.mu.Lock()
+	if b.err != nil {
+		b.mu.Unlock()
+		return 0, b.err
+	}
+	b.data = append(b.data, data...)
+	if len(b.data) >= b.size {
+		for b.flushing {
+			b.cond.Wait()
+		}
+		return len(data), c.flushLocked()
+	}
+	if b.interval > 0 && len(b.data) > 0 && !b.pending {
+		b.pending = true
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.interval, c.flushTimer)
+		} else {
+			b.timer.Reset(b.interval)
+		}
+	}
+	b.mu.Unlock()
+	return len(data), nil
+}
+
+// writeBuffers 写入多个缓冲，未开启写缓冲时使用 writev
+func (c *Conn) writeBuffers(buffers *net.Buffers) (int64, error) {
+	if c.writeBuffer != nil {
+		return buffers.WriteTo(bufferedWriter{c})
+	}
+	n, err := buffers.WriteTo(c.Conn)
+	if n > 0 {
+		atomic.AddInt64(&c.bytesWritten, n)
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *Conn) flushTimer() {
+	b := c.writeBuffer
+	b.mu.Lock()
+	// 正在写入的协程会写完缓冲中的数据
+	if !b.pending || b.flushing {
+		b.pending = false
+		b.mu.Unlock()
+		return
+	}
+	b.pending = false
+	c.flushLocked()
+}
+
+func (w bufferedWriter) Write(p []byte) (int, error) {
+	return w.c.write(p)
+}
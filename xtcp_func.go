@@ -13,11 +13,6 @@ const (
 	defaultReadBufferSize = 128
 )
 
-type Retry struct {
-	Count    int
-	Interval time.Duration
-}
-
 func NewNetConn(addr string, timeout ...time.Duration) (net.Conn, error) {
 	d := defaultConnTimeout
 	if len(timeout) > 0 {
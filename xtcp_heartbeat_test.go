@@ -0,0 +1,188 @@
+package xtcp_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/motai3/xtcp"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Heartbeat_Pong(t *testing.T) {
+	option := xtcp.PkgOption{Frame: &xtcp.PkgFrame{Magic: []byte{0xCA, 0xFE}}}
+	client, server := net.Pipe()
+	conn := xtcp.NewConnByNetConn(client)
+	defer conn.Close()
+	peer := xtcp.NewConnByNetConn(server)
+	defer peer.Close()
+
+	go func() {
+		// ping 不会返回给调用方，读取时自动回复 pong
+		data, err := peer.RecvPkg(option)
+		if err == nil {
+			peer.SendPkg(append([]byte(">"), data...), option)
+		}
+	}()
+	// net.Pipe 没有缓冲，需要先开始读取才能收到 pong
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		data, flags, err := conn.RecvPkgFlags(option)
+		assert.NoError(t, err)
+		assert.Equal(t, byte(0), flags)
+		assert.Equal(t, ">hello", string(data))
+	}()
+	assert.NoError(t, conn.Ping(option))
+	assert.NoError(t, conn.SendPkg([]byte("hello"), option))
+	<-done
+	// pong + 数据包
+	assert.Equal(t, int64(2*(2+2+2)+len(">hello")), conn.BytesRead())
+
+	assert.Error(t, conn.Ping())
+	assert.Error(t, conn.StartHeartbeat(xtcp.HeartbeatOption{}))
+}
+
+func Test_Heartbeat_Codec(t *testing.T) {
+	option := xtcp.PkgOption{Frame: &xtcp.PkgFrame{}}
+	client, server := net.Pipe()
+	conn := xtcp.NewConnByNetConn(client)
+	defer conn.Close()
+	peer := xtcp.NewConnByNetConn(server)
+	defer peer.Close()
+	peer.SetCodec(newPkgCodec(t, option))
+
+	go func() {
+		// RecvMsg 与 RecvPkg 一样跳过 ping 并回复 pong
+		data, err := peer.RecvMsg()
+		if err == nil {
+			peer.SendMsg(append([]byte(">"), data...))
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		data, err := conn.RecvPkg(option)
+		assert.NoError(t, err)
+		assert.Equal(t, ">hello", string(data))
+	}()
+	assert.NoError(t, conn.Ping(option))
+	assert.NoError(t, conn.SendPkg([]byte("hello"), option))
+	<-done
+	// pong + 数据包
+	assert.Equal(t, int64(2*(1+1+2)+len(">hello")), conn.BytesRead())
+
+	// 直接调用 Decode 时跳过心跳包
+	codec := newPkgCodec(t, option)
+	frame, err := codec.Encode([]byte("abc"))
+	assert.NoError(t, err)
+	data, err := codec.Decode(bufio.NewReader(bytes.NewReader(append([]byte{0, xtcp.PkgFlagPing, 0, 0}, frame...))))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+}
+
+func Test_Heartbeat_Server(t *testing.T) {
+	p := portList.PopFront().(int)
+	option := xtcp.PkgOption{Frame: &xtcp.PkgFrame{}}
+
+	var dead int32
+	closed := make(chan error, 10)
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvPkg(option)
+			if err != nil {
+				break
+			}
+			conn.SendPkg(data, option)
+		}
+	})
+	assert.NoError(t, server.SetHeartbeat(xtcp.HeartbeatOption{
+		Interval:  20 * time.Millisecond,
+		MaxMissed: 3,
+		PkgOption: option,
+		OnDead: func(c *xtcp.Conn) {
+			atomic.AddInt32(&dead, 1)
+		},
+	}))
+	server.SetOnClose(func(c *xtcp.Conn, info xtcp.CloseInfo) {
+		closed <- info.Reason
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	t.Run("Alive", func(t *testing.T) {
+		conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+		assert.NoError(t, err)
+		defer conn.Close()
+		go func() {
+			for {
+				if _, err := conn.RecvPkg(option); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(200 * time.Millisecond)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&dead))
+	})
+	// 上面的连接主动关闭
+	assert.NotEqual(t, xtcp.ErrHeartbeatTimeout, <-closed)
+
+	t.Run("Dead", func(t *testing.T) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", p))
+		assert.NoError(t, err)
+		defer conn.Close()
+		select {
+		case reason := <-closed:
+			assert.Equal(t, xtcp.ErrHeartbeatTimeout, reason)
+			assert.Equal(t, int32(1), atomic.LoadInt32(&dead))
+		case <-time.After(2 * time.Second):
+			t.Fatal("dead peer is not detected")
+		}
+	})
+}
+
+func Test_Heartbeat_StuckWriter(t *testing.T) {
+	option := xtcp.PkgOption{Frame: &xtcp.PkgFrame{}}
+	// 对端既不读取也不发送，写入一直阻塞
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := xtcp.NewConnByNetConn(client)
+	closed := make(chan error, 1)
+	conn.SetOnClose(func(c *xtcp.Conn, info xtcp.CloseInfo) {
+		closed <- info.Reason
+	})
+	go conn.SendPkg(make([]byte, 1024), option)
+	time.Sleep(10 * time.Millisecond)
+
+	// ping 等待写锁时仍然按时判断对端失联
+	assert.NoError(t, conn.StartHeartbeat(xtcp.HeartbeatOption{
+		Interval:  20 * time.Millisecond,
+		MaxMissed: 3,
+		PkgOption: option,
+	}))
+	select {
+	case reason := <-closed:
+		assert.Equal(t, xtcp.ErrHeartbeatTimeout, reason)
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead peer is not detected while a write is blocked")
+	}
+}
+
+func Test_Heartbeat_SharedCipher(t *testing.T) {
+	cipher, err := xtcp.NewPkgCipher(xtcp.PkgCipherServer, 1, cipherKey1)
+	assert.NoError(t, err)
+	option := xtcp.HeartbeatOption{PkgOption: xtcp.PkgOption{Frame: &xtcp.PkgFrame{}, Cipher: cipher}}
+
+	// 共用的心跳参数不能使用保存单个连接状态的 Cipher
+	server := xtcp.NewServer(":0", func(conn *xtcp.Conn) {})
+	assert.Equal(t, xtcp.ErrHeartbeatCipher, server.SetHeartbeat(option))
+
+	conn := xtcp.NewReconnectingConn("127.0.0.1:1", xtcp.ReconnectOption{Heartbeat: &option})
+	assert.Equal(t, xtcp.ReconnectClosed, conn.State())
+	assert.Equal(t, xtcp.ErrHeartbeatCipher, conn.SendPkg([]byte("a")))
+}
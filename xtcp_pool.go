@@ -9,8 +9,9 @@ import (
 // PoolConn 是一个具有池特性的连接
 type PoolConn struct {
 	*Conn
-	pool   *xpool.Pool
-	status int
+	pool    *xpool.Pool
+	status  int
+	callSeq uint32 // NewPoolClient 最后分配的请求 ID，连接放回连接池后由下一个 Client 继续分配
 }
 
 const (
@@ -27,10 +28,13 @@ func NewPoolConn(addr string, timeout ...time.Duration) (*PoolConn, error) {
 	var pool *xpool.Pool
 	pool = xpool.New(defaultPoolExpire, func() (interface{}, error) {
 		if conn, err := NewConn(addr, timeout...); err == nil {
-			return &PoolConn{conn, pool, connStatusActive}, nil
+			return &PoolConn{Conn: conn, pool: pool, status: connStatusActive}, nil
 		} else {
 			return nil, err
 		}
+	}, func(v interface{}) {
+		// 过期的连接直接关闭，触发 OnClose
+		v.(*PoolConn).Conn.Close()
 	})
 	v, _ := addressPoolMap.LoadOrStore(addr, pool)
 
@@ -51,6 +55,16 @@ func (c *PoolConn) Close() error {
 	return nil
 }
 
+// setStatus 根据读写结果更新连接状态，返回 err
+func (c *PoolConn) setStatus(err error) error {
+	if err != nil {
+		c.status = connStatusError
+	} else {
+		c.status = connStatusActive
+	}
+	return err
+}
+
 func (c *PoolConn) Send(data []byte, retry ...Retry) error {
 	err := c.Conn.Send(data, retry...)
 	if err != nil && (c.status == connStatusUnknown || c.status == connStatusError) {
@@ -99,22 +113,22 @@ func (c *PoolConn) RecvTil(til []byte, retry ...Retry) ([]byte, error) {
 	return data, err
 }
 
-func (c *PoolConn) RecvWithTimeout(length int, timeout time.Duration, retry ...Retry) (data []byte, err error) {
-	if err := c.SetreceiveDeadline(time.Now().Add(timeout)); err != nil {
-		return nil, err
-	}
-	defer c.SetreceiveDeadline(time.Time{})
-	data, err = c.Recv(length, retry...)
-	return
+func (c *PoolConn) RecvWithTimeout(length int, timeout time.Duration, retry ...Retry) ([]byte, error) {
+	data, err := c.Conn.RecvWithTimeout(length, timeout, retry...)
+	return data, c.setStatus(err)
 }
 
-func (c *PoolConn) SendWithTimeout(data []byte, timeout time.Duration, retry ...Retry) (err error) {
-	if err := c.SetSendDeadline(time.Now().Add(timeout)); err != nil {
-		return err
+func (c *PoolConn) SendWithTimeout(data []byte, timeout time.Duration, retry ...Retry) error {
+	err := c.Conn.SendWithTimeout(data, timeout, retry...)
+	if err != nil && (c.status == connStatusUnknown || c.status == connStatusError) {
+		if v, e := c.pool.Get(); e == nil {
+			c.Conn = v.(*PoolConn).Conn
+			err = c.Conn.SendWithTimeout(data, timeout, retry...)
+		} else {
+			err = e
+		}
 	}
-	defer c.SetSendDeadline(time.Time{})
-	err = c.Send(data, retry...)
-	return
+	return c.setStatus(err)
 }
 
 func (c *PoolConn) SendRecv(data []byte, receive int, retry ...Retry) ([]byte, error) {
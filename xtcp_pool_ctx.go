@@ -0,0 +1,41 @@
+package xtcp
+
+import "context"
+
+func (c *PoolConn) SendCtx(ctx context.Context, data []byte, retry ...Retry) error {
+	return c.setStatus(c.Conn.SendCtx(ctx, data, retry...))
+}
+
+func (c *PoolConn) RecvCtx(ctx context.Context, length int, retry ...Retry) ([]byte, error) {
+	data, err := c.Conn.RecvCtx(ctx, length, retry...)
+	return data, c.setStatus(err)
+}
+
+func (c *PoolConn) SendRecvCtx(ctx context.Context, data []byte, length int, retry ...Retry) ([]byte, error) {
+	result, err := c.Conn.SendRecvCtx(ctx, data, length, retry...)
+	return result, c.setStatus(err)
+}
+
+func (c *PoolConn) RecvLineCtx(ctx context.Context, retry ...Retry) ([]byte, error) {
+	data, err := c.Conn.RecvLineCtx(ctx, retry...)
+	return data, c.setStatus(err)
+}
+
+func (c *PoolConn) RecvTilCtx(ctx context.Context, til []byte, retry ...Retry) ([]byte, error) {
+	data, err := c.Conn.RecvTilCtx(ctx, til, retry...)
+	return data, c.setStatus(err)
+}
+
+func (c *PoolConn) SendPkgCtx(ctx context.Context, data []byte, option ...PkgOption) error {
+	return c.setStatus(c.Conn.SendPkgCtx(ctx, data, option...))
+}
+
+func (c *PoolConn) RecvPkgCtx(ctx context.Context, option ...PkgOption) ([]byte, error) {
+	data, err := c.Conn.RecvPkgCtx(ctx, option...)
+	return data, c.setStatus(err)
+}
+
+func (c *PoolConn) SendRecvPkgCtx(ctx context.Context, data []byte, option ...PkgOption) ([]byte, error) {
+	result, err := c.Conn.SendRecvPkgCtx(ctx, data, option...)
+	return result, c.setStatus(err)
+}
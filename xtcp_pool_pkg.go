@@ -29,22 +29,21 @@ func (c *PoolConn) RecvPkg(option ...PkgOption) ([]byte, error) {
 	return data, err
 }
 
-func (c *PoolConn) RecvPkgWithTimeout(timeout time.Duration, option ...PkgOption) (data []byte, err error) {
-	if err := c.SetreceiveDeadline(time.Now().Add(timeout)); err != nil {
-		return nil, err
-	}
-	defer c.SetreceiveDeadline(time.Time{})
-	data, err = c.RecvPkg(option...)
-	return
+func (c *PoolConn) RecvPkgWithTimeout(timeout time.Duration, option ...PkgOption) ([]byte, error) {
+	data, err := c.Conn.RecvPkgWithTimeout(timeout, option...)
+	return data, c.setStatus(err)
 }
 
 func (c *PoolConn) SendPkgWithTimeout(data []byte, timeout time.Duration, option ...PkgOption) (err error) {
-	if err := c.SetSendDeadline(time.Now().Add(timeout)); err != nil {
-		return err
+	if err = c.Conn.SendPkgWithTimeout(data, timeout, option...); err != nil && c.status == connStatusUnknown {
+		if v, e := c.pool.NewFunc(); e == nil {
+			c.Conn = v.(*PoolConn).Conn
+			err = c.Conn.SendPkgWithTimeout(data, timeout, option...)
+		} else {
+			err = e
+		}
 	}
-	defer c.SetSendDeadline(time.Time{})
-	err = c.SendPkg(data, option...)
-	return
+	return c.setStatus(err)
 }
 
 func (c *PoolConn) SendRecvPkg(data []byte, option ...PkgOption) ([]byte, error) {
@@ -62,3 +61,13 @@ func (c *PoolConn) SendRecvPkgWithTimeout(data []byte, timeout time.Duration, op
 		return nil, err
 	}
 }
+
+func (c *PoolConn) RecvPkgFlags(option ...PkgOption) ([]byte, byte, error) {
+	data, flags, err := c.Conn.RecvPkgFlags(option...)
+	if err != nil {
+		c.status = connStatusError
+	} else {
+		c.status = connStatusActive
+	}
+	return data, flags, err
+}
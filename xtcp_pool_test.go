@@ -88,3 +88,45 @@ func Test_Pool_Basic1(t *testing.T) {
 		assert.Equal(t, result, data)
 	})
 }
+
+func Test_Pool_Redial(t *testing.T) {
+	p := portList.PopFront().(int)
+	s := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvPkg()
+			if err != nil {
+				break
+			}
+			conn.SendPkg(data)
+		}
+	})
+	go s.Run()
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// 从连接池取出的连接已经失效时，WithTimeout 方法与 Send/SendPkg 一样重新获取连接
+	for _, send := range []func(conn *xtcp.PoolConn) error{
+		func(conn *xtcp.PoolConn) error {
+			return conn.SendPkgWithTimeout([]byte("hello"), time.Second)
+		},
+		func(conn *xtcp.PoolConn) error {
+			return conn.SendWithTimeout([]byte{0, 5, 'h', 'e', 'l', 'l', 'o'}, time.Second)
+		},
+	} {
+		conn, err := xtcp.NewPoolConn(fmt.Sprintf("127.0.0.1:%d", p))
+		assert.NoError(t, err)
+		_, err = conn.SendRecvPkg([]byte("hi"))
+		assert.NoError(t, err)
+		assert.NoError(t, conn.Close())
+
+		conn, err = xtcp.NewPoolConn(fmt.Sprintf("127.0.0.1:%d", p))
+		assert.NoError(t, err)
+		conn.Conn.Close()
+		assert.NoError(t, send(conn))
+		result, err := conn.RecvPkgWithTimeout(time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("hello"), result)
+		assert.NoError(t, conn.Close())
+	}
+}
@@ -0,0 +1,397 @@
+package xtcp
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ReconnectState 自动重连连接的状态
+type ReconnectState int
+
+const (
+	ReconnectConnecting   ReconnectState = iota // 正在拨号
+	ReconnectConnected                          // 已连接并完成握手
+	ReconnectDisconnected                       // 连接断开，等待重连
+	ReconnectClosed                             // 已关闭或放弃重连，不会再变化
+)
+
+// ReconnectOption 自动重连连接的参数
+type ReconnectOption struct {
+	Timeout   time.Duration // 拨号超时，默认 30 秒
+	TLSConfig *tls.Config   // 不为 nil 时使用 TLS 拨号
+	// Backoff 重连的退避策略，Count 为 0 表示不限次数，Retryable 为 nil 时所有错误都重连
+	// 零值时使用 100ms 起步、每次翻倍、最长 30 秒、浮动 20% 的退避
+	Backoff RetryPolicy
+	// Handshake 每次连接成功后、发送断线期间缓存的消息之前调用，可以用来重新登录，返回错误会关闭连接并重连
+	Handshake func(c *Conn) error
+	// OnStateChange 状态变化回调，err 为断开或放弃重连的原因
+	OnStateChange func(state ReconnectState, err error)
+	// Heartbeat 不为 nil 时在每个连接上启动心跳，对端失联时关闭连接，读写返回错误后触发重连
+	// 所有连接共用该参数，PkgOption.Cipher 不为 nil 时直接进入关闭状态，原因为 ErrHeartbeatCipher
+	Heartbeat *HeartbeatOption
+	// BufferSize 断线期间缓存的 SendPkg 消息条数，重连后按顺序发送，0 表示断线时直接返回 ErrReconnectDisconnected
+	BufferSize int
+}
+
+// ReconnectingConn 在底层连接断开时自动重新拨号的客户端连接
+// 连接是否断开由读写时遇到的不可重试错误（参见 IsRetryable）判断，超时不会触发重连
+type ReconnectingConn struct {
+	addr    string
+	option  ReconnectOption
+	mu      sync.Mutex
+	conn    *Conn
+	dialing *Conn         // 正在握手的连接，Close 时一起关闭
+	lost    chan struct{} // 当前连接断开时关闭
+	lostErr error         // 当前连接断开的原因
+	ready   chan struct{} // 连接成功时关闭，断开后重新创建
+	state   ReconnectState
+	pending []reconnectPkg
+	err     error // 放弃重连的原因
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// reconnectPkg 断线期间缓存的消息
+type reconnectPkg struct {
+	data   []byte
+	option []PkgOption
+}
+
+var (
+	ErrReconnectDisconnected = errors.New("connection is disconnected")
+	ErrReconnectClosed       = errors.New("reconnecting connection is closed")
+)
+
+var defaultReconnectBackoff = RetryPolicy{
+	Interval:    100 * time.Millisecond,
+	Multiplier:  2,
+	MaxInterval: 30 * time.Second,
+	Jitter:      0.2,
+}
+
+// NewReconnectingConn 创建自动重连的连接，在后台拨号，不等待第一次连接成功
+func NewReconnectingConn(addr string, option ...ReconnectOption) *ReconnectingConn {
+	rc := &ReconnectingConn{
+		addr:   addr,
+		ready:  make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	if len(option) > 0 {
+		rc.option = option[0]
+	}
+	if rc.option.Timeout == 0 {
+		rc.option.Timeout = defaultConnTimeout
+	}
+	backoff := &rc.option.Backoff
+	if backoff.Interval == 0 && backoff.Multiplier == 0 && backoff.MaxInterval == 0 {
+		backoff.Interval = defaultReconnectBackoff.Interval
+		backoff.Multiplier = defaultReconnectBackoff.Multiplier
+		backoff.MaxInterval = defaultReconnectBackoff.MaxInterval
+		if backoff.Jitter == 0 {
+			backoff.Jitter = defaultReconnectBackoff.Jitter
+		}
+	}
+	if backoff.Count == 0 {
+		backoff.Count = -1
+	}
+	if backoff.Retryable == nil {
+		backoff.Retryable = func(err error) bool {
+			return true
+		}
+	}
+	if err := checkSharedHeartbeat(rc.option.Heartbeat); err != nil {
+		rc.shutdown(err)
+		return rc
+	}
+	go rc.run()
+	return rc
+}
+
+// State 返回当前状态
+func (rc *ReconnectingConn) State() ReconnectState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.state
+}
+
+// Conn 返回当前的底层连接，未连接时返回 nil
+func (rc *ReconnectingConn) Conn() *Conn {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.conn
+}
+
+// SendPkg 发送 pkg 消息，断线期间按 BufferSize 缓存消息，缓存已满或者未开启缓存时返回 ErrReconnectDisconnected
+func (rc *ReconnectingConn) SendPkg(data []byte, option ...PkgOption) error {
+	rc.mu.Lock()
+	conn := rc.conn
+	if conn == nil {
+		defer rc.mu.Unlock()
+		if rc.state == ReconnectClosed {
+			return rc.closedErr()
+		}
+		if len(rc.pending) >= rc.option.BufferSize {
+			return ErrReconnectDisconnected
+		}
+		rc.pending = append(rc.pending, reconnectPkg{
+			data:   append([]byte(nil), data...),
+			option: option,
+		})
+		return nil
+	}
+	rc.mu.Unlock()
+	err := conn.SendPkg(data, option...)
+	rc.check(conn, err)
+	return err
+}
+
+// RecvPkg 接收 pkg 消息，断线期间阻塞到重新连接成功
+// 连接断开时返回读取的错误，已经读取的半条消息会丢失，调用方可以再次调用等待重连
+func (rc *ReconnectingConn) RecvPkg(option ...PkgOption) ([]byte, error) {
+	conn, err := rc.wait()
+	if err != nil {
+		return nil, err
+	}
+	data, err := conn.RecvPkg(option...)
+	rc.check(conn, err)
+	return data, err
+}
+
+func (rc *ReconnectingConn) SendRecvPkg(data []byte, option ...PkgOption) ([]byte, error) {
+	if err := rc.SendPkg(data, option...); err != nil {
+		return nil, err
+	}
+	return rc.RecvPkg(option...)
+}
+
+// Close 关闭当前连接并停止重连，缓存中未发送的消息会被丢弃
+func (rc *ReconnectingConn) Close() error {
+	var err error
+	rc.once.Do(func() {
+		close(rc.closed)
+		rc.mu.Lock()
+		conn, dialing := rc.conn, rc.dialing
+		rc.mu.Unlock()
+		if dialing != nil {
+			dialing.Close()
+		}
+		if conn != nil {
+			err = conn.Close()
+		}
+	})
+	return err
+}
+
+// run 拨号并在连接断开后重连，直到 Close 或者超过重连策略的限制
+func (rc *ReconnectingConn) run() {
+	r := newRetrier(rc.option.Backoff)
+	for {
+		rc.setState(ReconnectConnecting, nil)
+		conn, err := rc.dial()
+		if err == nil {
+			lost, e := rc.install(conn)
+			if e == nil {
+				r = newRetrier(rc.option.Backoff)
+				select {
+				case <-lost:
+				case <-rc.closed:
+				}
+				rc.mu.Lock()
+				err = rc.lostErr
+				rc.mu.Unlock()
+			} else {
+				err = e
+			}
+		}
+		if rc.isClosed() {
+			rc.shutdown(nil)
+			return
+		}
+		rc.setState(ReconnectDisconnected, err)
+		delay, ok := r.backoff(err)
+		if !ok {
+			rc.shutdown(err)
+			return
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-rc.closed:
+			timer.Stop()
+			rc.shutdown(nil)
+			return
+		}
+	}
+}
+
+func (rc *ReconnectingConn) dial() (*Conn, error) {
+	if rc.option.TLSConfig != nil {
+		conn, err := NewNetConnTLS(rc.addr, rc.option.TLSConfig, rc.option.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return newClientConn(conn)
+	}
+	conn, err := NewNetConn(rc.addr, rc.option.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newClientConn(conn)
+}
+
+// install 完成握手、发送缓存的消息后把 conn 设置为当前连接
+func (rc *ReconnectingConn) install(conn *Conn) (<-chan struct{}, error) {
+	rc.mu.Lock()
+	rc.dialing = conn
+	rc.mu.Unlock()
+	if rc.isClosed() {
+		conn.Close()
+		return nil, ErrReconnectClosed
+	}
+	if rc.option.Handshake != nil {
+		if err := rc.option.Handshake(conn); err != nil {
+			return nil, rc.abort(conn, err)
+		}
+	}
+	if rc.option.Heartbeat != nil {
+		heartbeat := *rc.option.Heartbeat
+		onDead := heartbeat.OnDead
+		// 空闲时没有读写，check 发现不了连接断开，对端失联时直接触发重连
+		heartbeat.OnDead = func(c *Conn) {
+			if onDead != nil {
+				onDead(c)
+			}
+			rc.lose(c, ErrHeartbeatTimeout)
+		}
+		if err := conn.StartHeartbeat(heartbeat); err != nil {
+			return nil, rc.abort(conn, err)
+		}
+	}
+	rc.mu.Lock()
+	rc.dialing = nil
+	// 持有锁发送缓存，保证缓存的消息在之后的 SendPkg 之前发出
+	for len(rc.pending) > 0 {
+		pkg := rc.pending[0]
+		if err := conn.SendPkg(pkg.data, pkg.option...); err != nil {
+			rc.mu.Unlock()
+			conn.CloseWithReason(err)
+			return nil, err
+		}
+		rc.pending[0] = reconnectPkg{}
+		rc.pending = rc.pending[1:]
+	}
+	rc.pending = nil
+	if rc.isClosed() {
+		rc.mu.Unlock()
+		conn.Close()
+		return nil, ErrReconnectClosed
+	}
+	lost := make(chan struct{})
+	rc.conn = conn
+	rc.lost = lost
+	rc.lostErr = nil
+	close(rc.ready)
+	rc.mu.Unlock()
+	rc.setState(ReconnectConnected, nil)
+	return lost, nil
+}
+
+// abort 关闭握手失败的连接
+func (rc *ReconnectingConn) abort(conn *Conn, err error) error {
+	rc.mu.Lock()
+	rc.dialing = nil
+	rc.mu.Unlock()
+	conn.CloseWithReason(err)
+	return err
+}
+
+// check 在读写出现不可重试的错误时断开连接，通知 run 重连
+func (rc *ReconnectingConn) check(conn *Conn, err error) {
+	if err == nil || IsRetryable(err) {
+		return
+	}
+	rc.lose(conn, err)
+}
+
+// lose 断开 conn 并通知 run 重连，conn 已经不是当前连接时不做处理
+func (rc *ReconnectingConn) lose(conn *Conn, err error) {
+	rc.mu.Lock()
+	if rc.conn != conn {
+		rc.mu.Unlock()
+		return
+	}
+	rc.conn = nil
+	rc.ready = make(chan struct{})
+	rc.lostErr = err
+	close(rc.lost)
+	rc.mu.Unlock()
+	conn.CloseWithReason(err)
+}
+
+// wait 等待连接可用
+func (rc *ReconnectingConn) wait() (*Conn, error) {
+	for {
+		rc.mu.Lock()
+		conn, ready := rc.conn, rc.ready
+		if conn == nil && rc.state == ReconnectClosed {
+			defer rc.mu.Unlock()
+			return nil, rc.closedErr()
+		}
+		rc.mu.Unlock()
+		if conn != nil {
+			return conn, nil
+		}
+		select {
+		case <-ready:
+		case <-rc.closed:
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+			return nil, rc.closedErr()
+		}
+	}
+}
+
+func (rc *ReconnectingConn) setState(state ReconnectState, err error) {
+	rc.mu.Lock()
+	rc.state = state
+	rc.mu.Unlock()
+	if rc.option.OnStateChange != nil {
+		rc.option.OnStateChange(state, err)
+	}
+}
+
+// shutdown 进入关闭状态，唤醒等待连接的调用
+func (rc *ReconnectingConn) shutdown(err error) {
+	rc.mu.Lock()
+	rc.err = err
+	rc.pending = nil
+	conn := rc.conn
+	rc.conn = nil
+	rc.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+	rc.setState(ReconnectClosed, err)
+	rc.once.Do(func() {
+		close(rc.closed)
+	})
+}
+
+// closedErr 返回关闭后读写的错误，调用时需要持有 mu
+func (rc *ReconnectingConn) closedErr() error {
+	if rc.err != nil {
+		return rc.err
+	}
+	return ErrReconnectClosed
+}
+
+func (rc *ReconnectingConn) isClosed() bool {
+	select {
+	case <-rc.closed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,149 @@
+package xtcp_test
+
+import (
+	"fmt"
+	"github.com/motai3/xtcp"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Reconnect_Basic(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	var logins int32
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			data, err := conn.RecvPkg()
+			if err != nil {
+				break
+			}
+			switch string(data) {
+			case "login":
+				atomic.AddInt32(&logins, 1)
+			case "kick":
+				return
+			default:
+				conn.SendPkg(append([]byte(">"), data...))
+			}
+		}
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	states := make(chan xtcp.ReconnectState, 100)
+	conn := xtcp.NewReconnectingConn(fmt.Sprintf("127.0.0.1:%d", p), xtcp.ReconnectOption{
+		Backoff: xtcp.RetryPolicy{Interval: 50 * time.Millisecond},
+		Handshake: func(c *xtcp.Conn) error {
+			return c.SendPkg([]byte("login"))
+		},
+		OnStateChange: func(state xtcp.ReconnectState, err error) {
+			states <- state
+		},
+		BufferSize: 1,
+	})
+	waitState := func(state xtcp.ReconnectState) {
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case s := <-states:
+				if s == state {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("state %d not reached", state)
+			}
+		}
+	}
+	waitState(xtcp.ReconnectConnected)
+
+	data, err := conn.SendRecvPkg([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, ">a", string(data))
+
+	// 服务端断开后 RecvPkg 返回错误并触发重连，断线期间的消息在重连后发出
+	assert.NoError(t, conn.SendPkg([]byte("kick")))
+	_, err = conn.RecvPkg()
+	assert.Error(t, err)
+	assert.NoError(t, conn.SendPkg([]byte("b")))
+	data, err = conn.RecvPkg()
+	assert.NoError(t, err)
+	assert.Equal(t, ">b", string(data))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&logins))
+
+	assert.NoError(t, conn.Close())
+	waitState(xtcp.ReconnectClosed)
+	assert.Equal(t, xtcp.ErrReconnectClosed, conn.SendPkg([]byte("c")))
+	_, err = conn.RecvPkg()
+	assert.Equal(t, xtcp.ErrReconnectClosed, err)
+}
+
+func Test_Reconnect_GiveUp(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	closed := make(chan error, 1)
+	conn := xtcp.NewReconnectingConn(fmt.Sprintf("127.0.0.1:%d", p), xtcp.ReconnectOption{
+		Backoff: xtcp.RetryPolicy{Count: 2, Interval: 10 * time.Millisecond},
+		OnStateChange: func(state xtcp.ReconnectState, err error) {
+			if state == xtcp.ReconnectClosed {
+				closed <- err
+			}
+		},
+	})
+	defer conn.Close()
+	assert.Equal(t, xtcp.ErrReconnectDisconnected, conn.SendPkg([]byte("a")))
+	select {
+	case err := <-closed:
+		assert.Error(t, err)
+		_, recvErr := conn.RecvPkg()
+		assert.Equal(t, err, recvErr)
+	case <-time.After(2 * time.Second):
+		t.Fatal("reconnecting is not given up")
+	}
+}
+
+func Test_Reconnect_Heartbeat(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	// 服务端不回复 pong
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	})
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	var dead int32
+	lost := make(chan error, 10)
+	conn := xtcp.NewReconnectingConn(fmt.Sprintf("127.0.0.1:%d", p), xtcp.ReconnectOption{
+		Backoff: xtcp.RetryPolicy{Interval: 10 * time.Millisecond},
+		Heartbeat: &xtcp.HeartbeatOption{
+			Interval:  20 * time.Millisecond,
+			MaxMissed: 2,
+			PkgOption: xtcp.PkgOption{Frame: &xtcp.PkgFrame{}},
+			OnDead: func(c *xtcp.Conn) {
+				atomic.AddInt32(&dead, 1)
+			},
+		},
+		OnStateChange: func(state xtcp.ReconnectState, err error) {
+			if state == xtcp.ReconnectDisconnected {
+				lost <- err
+			}
+		},
+	})
+	defer conn.Close()
+
+	// 没有读写的连接在对端失联时也会进入断开状态并重连
+	select {
+	case err := <-lost:
+		assert.Equal(t, xtcp.ErrHeartbeatTimeout, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&dead))
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead peer is not detected")
+	}
+}
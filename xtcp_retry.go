@@ -0,0 +1,199 @@
+package xtcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy 读写失败时的重试策略，调用方传入的值不会被修改，可以在多次调用之间复用
+// 等待时间从 Interval 开始，每次重试乘以 Multiplier，不超过 MaxInterval，再按 Jitter 比例随机浮动
+type RetryPolicy struct {
+	Count       int                  // 最大重试次数，0 表示不重试，小于 0 表示不限次数
+	Interval    time.Duration        // 第一次重试前的等待时间，默认 100ms
+	Multiplier  float64              // 等待时间的增长倍数，不大于 1 时为固定间隔
+	MaxInterval time.Duration        // 等待时间上限，0 表示不限制
+	Jitter      float64              // 等待时间的随机浮动比例，取值 0~1
+	MaxElapsed  time.Duration        // 从第一次尝试开始允许的最长时间，0 表示不限制
+	Retryable   func(err error) bool // 判断错误是否可以重试，为 nil 时使用 IsRetryable
+	Budget      *RetryBudget         // 多个连接共享的重试预算，为 nil 时不限制
+	ctx         context.Context      // Ctx 方法设置，ctx 结束后不再重试
+}
+
+// Retry 是 RetryPolicy 的别名，保留原来的写法 Retry{Count: 3, Interval: time.Second}
+type Retry = RetryPolicy
+
+// RetryBudget 限制重试占正常请求的比例，避免服务故障时所有连接同时重试放大流量
+// 每次重试消耗一个令牌，每次成功的读写归还 ratio 个令牌，令牌数不超过 max
+type RetryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+// retrier 记录一次读写操作的重试状态
+type retrier struct {
+	policy  RetryPolicy
+	enabled bool
+	attempt int
+	start   time.Time
+}
+
+// NewRetryBudget 创建重试预算，初始有 max 个令牌，ratio 为每次成功归还的令牌数，例如 0.1 表示重试不超过成功请求的 10%
+func NewRetryBudget(max int, ratio float64) *RetryBudget {
+	return &RetryBudget{
+		tokens: float64(max),
+		max:    float64(max),
+		ratio:  ratio,
+	}
+}
+
+// Tokens 返回当前剩余的令牌数
+func (b *RetryBudget) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens
+}
+
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *RetryBudget) deposit() {
+	b.mu.Lock()
+	b.tokens = math.Min(b.tokens+b.ratio, b.max)
+	b.mu.Unlock()
+}
+
+// IsRetryable 默认的重试判断，对端关闭、连接被重置或者本地已关闭时重试没有意义，其他错误（例如超时）可以重试
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return false
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNABORTED):
+		return false
+	case errors.Is(err, ErrLineTooLong):
+		return false
+	}
+	return true
+}
+
+// withRetryContext 返回 ctx 结束后停止重试的重试策略，不修改调用方传入的值
+func withRetryContext(ctx context.Context, retry []Retry) []Retry {
+	if len(retry) == 0 || retry[0].Count == 0 || ctx.Done() == nil {
+		return retry
+	}
+	p := retry[0]
+	p.ctx = ctx
+	return []Retry{p}
+}
+
+func newRetrier(retry ...Retry) retrier {
+	if len(retry) == 0 || retry[0].Count == 0 {
+		return retrier{}
+	}
+	r := retrier{
+		policy:  retry[0],
+		enabled: true,
+	}
+	if r.policy.MaxElapsed > 0 {
+		r.start = time.Now()
+	}
+	return r
+}
+
+// retry 判断 err 是否需要重试，需要时等待退避时间后返回 true，等待期间 ctx 结束时返回 false
+func (r *retrier) retry(err error) bool {
+	delay, ok := r.backoff(err)
+	if !ok {
+		return false
+	}
+	if r.policy.ctx == nil {
+		time.Sleep(delay)
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return r.policy.ctx.Err() == nil
+	case <-r.policy.ctx.Done():
+		return false
+	}
+}
+
+// backoff 判断 err 是否需要重试，需要时返回下一次重试前的等待时间
+func (r *retrier) backoff(err error) (time.Duration, bool) {
+	if !r.enabled {
+		return 0, false
+	}
+	p := &r.policy
+	if p.Count > 0 && r.attempt >= p.Count {
+		return 0, false
+	}
+	// ctx 结束导致的超时不能重试
+	if p.ctx != nil && p.ctx.Err() != nil {
+		return 0, false
+	}
+	if p.Retryable != nil {
+		if !p.Retryable(err) {
+			return 0, false
+		}
+	} else if !IsRetryable(err) {
+		return 0, false
+	}
+	delay := r.delay()
+	if p.MaxElapsed > 0 && time.Since(r.start)+delay > p.MaxElapsed {
+		return 0, false
+	}
+	if p.Budget != nil && !p.Budget.withdraw() {
+		return 0, false
+	}
+	r.attempt++
+	return delay, true
+}
+
+// succeed 在读写成功时调用，向重试预算归还令牌
+func (r *retrier) succeed() {
+	if r.enabled && r.policy.Budget != nil {
+		r.policy.Budget.deposit()
+	}
+}
+
+// delay 计算下一次重试前的等待时间
+func (r *retrier) delay() time.Duration {
+	p := &r.policy
+	delay := p.Interval
+	if delay <= 0 {
+		delay = defaultRetryInternal
+	}
+	if p.Multiplier > 1 {
+		if f := float64(delay) * math.Pow(p.Multiplier, float64(r.attempt)); f < math.MaxInt64 {
+			delay = time.Duration(f)
+		} else {
+			delay = math.MaxInt64
+		}
+	}
+	if p.MaxInterval > 0 && delay > p.MaxInterval {
+		delay = p.MaxInterval
+	}
+	if p.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
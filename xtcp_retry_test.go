@@ -0,0 +1,111 @@
+package xtcp_test
+
+import (
+	"errors"
+	"github.com/motai3/xtcp"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// retryTimeoutConn 返回一个 receive deadline 已经过期的连接，每次读取都会立即超时
+func retryTimeoutConn(t *testing.T) *xtcp.Conn {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	conn := xtcp.NewConnByNetConn(client)
+	assert.NoError(t, conn.SetreceiveDeadline(time.Now().Add(-time.Second)))
+	return conn
+}
+
+func Test_Retry_Policy(t *testing.T) {
+	t.Run("NotMutated", func(t *testing.T) {
+		conn := retryTimeoutConn(t)
+		retry := []xtcp.Retry{{Count: 2, Interval: 5 * time.Millisecond}}
+		_, err := conn.Recv(1, retry...)
+		assert.Error(t, err)
+		_, err = conn.Recv(1, retry...)
+		assert.Error(t, err)
+		assert.Equal(t, 2, retry[0].Count)
+		assert.Equal(t, 5*time.Millisecond, retry[0].Interval)
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		conn := retryTimeoutConn(t)
+		start := time.Now()
+		_, err := conn.Recv(1, xtcp.RetryPolicy{Count: 3, Interval: 10 * time.Millisecond, Multiplier: 2})
+		assert.Error(t, err)
+		// 10ms + 20ms + 40ms
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(70*time.Millisecond))
+	})
+
+	t.Run("MaxElapsed", func(t *testing.T) {
+		conn := retryTimeoutConn(t)
+		start := time.Now()
+		_, err := conn.Recv(1, xtcp.RetryPolicy{Count: -1, Interval: 10 * time.Millisecond, MaxElapsed: 50 * time.Millisecond})
+		assert.Error(t, err)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	})
+
+	t.Run("Retryable", func(t *testing.T) {
+		conn := retryTimeoutConn(t)
+		var calls int
+		_, err := conn.Recv(1, xtcp.RetryPolicy{Count: 5, Interval: time.Millisecond, Retryable: func(err error) bool {
+			calls++
+			return calls < 3
+		}})
+		assert.Error(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("Budget", func(t *testing.T) {
+		conn := retryTimeoutConn(t)
+		budget := xtcp.NewRetryBudget(2, 0.5)
+		policy := xtcp.RetryPolicy{Count: 5, Interval: time.Millisecond, Budget: budget}
+		_, err := conn.Recv(1, policy)
+		assert.Error(t, err)
+		assert.Equal(t, float64(0), budget.Tokens())
+		_, err = conn.Recv(1, policy)
+		assert.Error(t, err)
+		assert.Equal(t, float64(0), budget.Tokens())
+	})
+
+	t.Run("IsRetryable", func(t *testing.T) {
+		conn := retryTimeoutConn(t)
+		_, err := conn.Recv(1)
+		assert.True(t, xtcp.IsRetryable(err))
+		assert.False(t, xtcp.IsRetryable(io.EOF))
+		assert.False(t, xtcp.IsRetryable(io.ErrUnexpectedEOF))
+		assert.False(t, xtcp.IsRetryable(net.ErrClosed))
+		assert.True(t, xtcp.IsRetryable(errors.New("temporary")))
+	})
+}
+
+func Test_Retry_PartialWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := xtcp.NewConnByNetConn(client)
+	defer conn.Close()
+
+	received := make(chan []byte)
+	go func() {
+		// 先读取一半，等发送超时后再读取剩余的数据
+		buffer := make([]byte, 10)
+		n, _ := io.ReadFull(server, buffer[:5])
+		time.Sleep(50 * time.Millisecond)
+		m, _ := io.ReadFull(server, buffer[5:])
+		received <- buffer[:n+m]
+	}()
+
+	assert.NoError(t, conn.SetSendDeadline(time.Now().Add(20*time.Millisecond)))
+	err := conn.Send([]byte("0123456789"), xtcp.RetryPolicy{Count: 1, Interval: time.Millisecond, Retryable: func(err error) bool {
+		conn.SetSendDeadline(time.Time{})
+		return xtcp.IsRetryable(err)
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("0123456789"), <-received)
+}
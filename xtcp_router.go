@@ -0,0 +1,269 @@
+package xtcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	cmdSizeDefault = 2 // 默认命令字长度
+)
+
+// HandlerFunc 处理一个已经解析出命令字的请求
+type HandlerFunc func(r *Request)
+
+// MiddlewareFunc 包装 HandlerFunc，可以在处理前后插入逻辑，不调用 next 即中断处理
+type MiddlewareFunc func(next HandlerFunc) HandlerFunc
+
+// Request 是路由分发给 HandlerFunc 的一条消息
+type Request struct {
+	Conn   *Conn
+	Cmd    uint32
+	Data   []byte
+	router *Router
+}
+
+// RouterOption 路由的消息格式，CmdSize 为命令字长度，只支持 2 或 4 字节
+type RouterOption struct {
+	CmdSize   int
+	PkgOption PkgOption
+}
+
+// Router 按命令字把 pkg 消息分发到对应的 HandlerFunc
+// 消息格式为 命令字(大端) + 消息体，外层仍然是 SendPkg/RecvPkg 的长度前缀包
+type Router struct {
+	mu          sync.RWMutex
+	option      RouterOption
+	handlers    map[uint32]HandlerFunc
+	middlewares []MiddlewareFunc
+	notFound    HandlerFunc
+}
+
+// RouterGroup 共享命令字前缀和中间件的一组路由
+type RouterGroup struct {
+	router      *Router
+	prefix      uint32
+	middlewares []MiddlewareFunc
+}
+
+var ErrRouterCmdShort = errors.New("message is shorter than command size")
+
+// NewRouter 创建路由，CmdSize 为 0 时使用 2 字节，不是 2 或 4 时 panic
+func NewRouter(option ...RouterOption) *Router {
+	r := &Router{
+		handlers: make(map[uint32]HandlerFunc),
+	}
+	if len(option) > 0 {
+		r.option = option[0]
+	}
+	if r.option.CmdSize == 0 {
+		r.option.CmdSize = cmdSizeDefault
+	}
+	if r.option.CmdSize != 2 && r.option.CmdSize != 4 {
+		panic(fmt.Sprintf(`invalid cmd size %d, only 2 or 4 bytes are supported`, r.option.CmdSize))
+	}
+	return r
+}
+
+// Use 添加全局中间件，对所有路由以及 NotFound 生效
+func (r *Router) Use(middleware ...MiddlewareFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middlewares = append(r.middlewares, middleware...)
+}
+
+// Handle 注册命令字的处理函数，命令字重复注册或者超出 CmdSize 的范围时 panic
+func (r *Router) Handle(cmd uint32, handler HandlerFunc, middleware ...MiddlewareFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if uint64(cmd) > r.maxCmd() {
+		panic(fmt.Sprintf(`cmd 0x%X overflows %d bytes`, cmd, r.option.CmdSize))
+	}
+	if _, ok := r.handlers[cmd]; ok {
+		panic(fmt.Sprintf(`duplicated route for cmd 0x%X`, cmd))
+	}
+	r.handlers[cmd] = chain(handler, middleware)
+}
+
+// NotFound 设置没有匹配路由时的处理函数，默认忽略该消息
+func (r *Router) NotFound(handler HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notFound = handler
+}
+
+// Group 创建一个路由组，组内注册的命令字为 prefix + cmd
+// 不同组的命令字范围可以重叠，重叠部分重复注册同一个命令字时 Handle 会 panic，prefix + cmd 超出 CmdSize 的范围时同样 panic
+func (r *Router) Group(prefix uint32, middleware ...MiddlewareFunc) *RouterGroup {
+	return &RouterGroup{
+		router:      r,
+		prefix:      prefix,
+		middlewares: middleware,
+	}
+}
+
+func (g *RouterGroup) Use(middleware ...MiddlewareFunc) {
+	g.middlewares = append(g.middlewares, middleware...)
+}
+
+func (g *RouterGroup) Handle(cmd uint32, handler HandlerFunc, middleware ...MiddlewareFunc) {
+	g.router.Handle(g.cmd(cmd), handler, append(g.middlewares[:len(g.middlewares):len(g.middlewares)], middleware...)...)
+}
+
+func (g *RouterGroup) Group(prefix uint32, middleware ...MiddlewareFunc) *RouterGroup {
+	return &RouterGroup{
+		router:      g.router,
+		prefix:      g.cmd(prefix),
+		middlewares: append(g.middlewares[:len(g.middlewares):len(g.middlewares)], middleware...),
+	}
+}
+
+// cmd 返回组内命令字对应的完整命令字，超出 CmdSize 的范围时 panic，避免溢出后与其他组的命令字冲突
+func (g *RouterGroup) cmd(cmd uint32) uint32 {
+	full := uint64(g.prefix) + uint64(cmd)
+	if full > g.router.maxCmd() {
+		panic(fmt.Sprintf(`cmd 0x%X + 0x%X overflows %d bytes`, g.prefix, cmd, g.router.option.CmdSize))
+	}
+	return uint32(full)
+}
+
+// maxCmd 返回命令字能表示的最大值
+func (r *Router) maxCmd() uint64 {
+	return 1<<(8*uint(r.option.CmdSize)) - 1
+}
+
+// Serve 循环读取消息并分发，可以直接作为 Server 的 handler 使用
+func (r *Router) Serve(conn *Conn) {
+	defer conn.Close()
+	for {
+		data, err := conn.RecvPkg(r.option.PkgOption)
+		if err != nil {
+			break
+		}
+		if err = r.Dispatch(conn, data); err != nil {
+			break
+		}
+	}
+}
+
+// Dispatch 解析一条消息的命令字并调用对应的处理函数
+func (r *Router) Dispatch(conn *Conn, data []byte) error {
+	cmd, body, err := UnpackCmd(data, r.option.CmdSize)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	handler, ok := r.handlers[cmd]
+	if !ok {
+		handler = r.notFound
+	}
+	middlewares := r.middlewares
+	r.mu.RUnlock()
+	if handler == nil {
+		return nil
+	}
+	chain(handler, middlewares)(&Request{
+		Conn:   conn,
+		Cmd:    cmd,
+		Data:   body,
+		router: r,
+	})
+	return nil
+}
+
+// Reply 使用请求的命令字回复消息
+func (r *Request) Reply(data []byte) error {
+	return r.Conn.SendCmd(r.Cmd, data, r.router.option)
+}
+
+// SendCmd 发送带命令字的 pkg 消息
+func (c *Conn) SendCmd(cmd uint32, data []byte, option ...RouterOption) error {
+	routerOption := getRouterOption(option...)
+	buffer, err := PackCmd(cmd, data, routerOption.CmdSize)
+	if err != nil {
+		return err
+	}
+	return c.SendPkg(buffer, routerOption.PkgOption)
+}
+
+// RecvCmd 接收带命令字的 pkg 消息
+func (c *Conn) RecvCmd(option ...RouterOption) (uint32, []byte, error) {
+	routerOption := getRouterOption(option...)
+	data, err := c.RecvPkg(routerOption.PkgOption)
+	if err != nil {
+		return 0, nil, err
+	}
+	return UnpackCmd(data, routerOption.CmdSize)
+}
+
+func (c *PoolConn) SendCmd(cmd uint32, data []byte, option ...RouterOption) error {
+	routerOption := getRouterOption(option...)
+	buffer, err := PackCmd(cmd, data, routerOption.CmdSize)
+	if err != nil {
+		return err
+	}
+	return c.SendPkg(buffer, routerOption.PkgOption)
+}
+
+func (c *PoolConn) RecvCmd(option ...RouterOption) (uint32, []byte, error) {
+	routerOption := getRouterOption(option...)
+	data, err := c.RecvPkg(routerOption.PkgOption)
+	if err != nil {
+		return 0, nil, err
+	}
+	return UnpackCmd(data, routerOption.CmdSize)
+}
+
+// PackCmd 在消息体前加上命令字
+func PackCmd(cmd uint32, data []byte, cmdSize int) ([]byte, error) {
+	buffer := make([]byte, cmdSize+len(data))
+	switch cmdSize {
+	case 2:
+		if cmd > 0xFFFF {
+			return nil, fmt.Errorf(`cmd 0x%X overflows %d bytes`, cmd, cmdSize)
+		}
+		binary.BigEndian.PutUint16(buffer, uint16(cmd))
+	case 4:
+		binary.BigEndian.PutUint32(buffer, cmd)
+	default:
+		return nil, fmt.Errorf(`invalid cmd size %d`, cmdSize)
+	}
+	copy(buffer[cmdSize:], data)
+	return buffer, nil
+}
+
+// UnpackCmd 拆分命令字和消息体，消息体与 data 共享内存
+func UnpackCmd(data []byte, cmdSize int) (uint32, []byte, error) {
+	if len(data) < cmdSize {
+		return 0, nil, ErrRouterCmdShort
+	}
+	switch cmdSize {
+	case 2:
+		return uint32(binary.BigEndian.Uint16(data)), data[2:], nil
+	case 4:
+		return binary.BigEndian.Uint32(data), data[4:], nil
+	default:
+		return 0, nil, fmt.Errorf(`invalid cmd size %d`, cmdSize)
+	}
+}
+
+func getRouterOption(option ...RouterOption) RouterOption {
+	routerOption := RouterOption{}
+	if len(option) > 0 {
+		routerOption = option[0]
+	}
+	if routerOption.CmdSize == 0 {
+		routerOption.CmdSize = cmdSizeDefault
+	}
+	return routerOption
+}
+
+// chain 按注册顺序包装中间件，先注册的在最外层
+func chain(handler HandlerFunc, middlewares []MiddlewareFunc) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
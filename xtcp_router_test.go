@@ -0,0 +1,125 @@
+package xtcp_test
+
+import (
+	"fmt"
+	"github.com/motai3/xtcp"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_Router_Basic(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	router := xtcp.NewRouter()
+	var calls []string
+	router.Use(func(next xtcp.HandlerFunc) xtcp.HandlerFunc {
+		return func(r *xtcp.Request) {
+			calls = append(calls, "global")
+			next(r)
+		}
+	})
+	router.Handle(0x0001, func(r *xtcp.Request) {
+		r.Reply(append([]byte("echo:"), r.Data...))
+	})
+	user := router.Group(0x0100, func(next xtcp.HandlerFunc) xtcp.HandlerFunc {
+		return func(r *xtcp.Request) {
+			calls = append(calls, "group")
+			next(r)
+		}
+	})
+	user.Handle(0x01, func(r *xtcp.Request) {
+		r.Reply([]byte("login"))
+	})
+	router.NotFound(func(r *xtcp.Request) {
+		r.Conn.SendCmd(0xFFFF, []byte("not found"))
+	})
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), router.Serve)
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	t.Run("Handle", func(t *testing.T) {
+		assert.NoError(t, conn.SendCmd(0x0001, []byte("hi")))
+		cmd, data, err := conn.RecvCmd()
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0x0001), cmd)
+		assert.Equal(t, []byte("echo:hi"), data)
+	})
+
+	t.Run("Group", func(t *testing.T) {
+		calls = nil
+		assert.NoError(t, conn.SendCmd(0x0101, nil))
+		cmd, data, err := conn.RecvCmd()
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0x0101), cmd)
+		assert.Equal(t, []byte("login"), data)
+		assert.Equal(t, []string{"global", "group"}, calls)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		assert.NoError(t, conn.SendCmd(0x0202, nil))
+		cmd, data, err := conn.RecvCmd()
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0xFFFF), cmd)
+		assert.Equal(t, []byte("not found"), data)
+	})
+}
+
+func Test_Router_Conflict(t *testing.T) {
+	router := xtcp.NewRouter()
+	handler := func(r *xtcp.Request) {}
+	router.Handle(0x0101, handler)
+
+	// 不同组的命令字范围重叠时，重复注册同一个命令字会 panic
+	user := router.Group(0x0100)
+	assert.Panics(t, func() {
+		user.Handle(0x01, handler)
+	})
+	assert.Panics(t, func() {
+		router.Group(0x0000).Group(0x0100).Handle(0x01, handler)
+	})
+	user.Handle(0x02, handler)
+
+	// 超出命令字范围时 panic，不会溢出到其他命令字
+	assert.Panics(t, func() {
+		router.Handle(0x10000, handler)
+	})
+	assert.Panics(t, func() {
+		router.Group(0xFF00).Handle(0x100, handler)
+	})
+	assert.Panics(t, func() {
+		router.Group(0xFF00).Group(0x100)
+	})
+	router.Group(0xFF00).Handle(0xFF, handler)
+
+	// 只支持 2 或 4 字节的命令字
+	for _, size := range []int{-1, 1, 3, 8} {
+		assert.Panics(t, func() {
+			xtcp.NewRouter(xtcp.RouterOption{CmdSize: size})
+		})
+	}
+
+	wide := xtcp.NewRouter(xtcp.RouterOption{CmdSize: 4})
+	wide.Handle(0x0000FFFF, handler)
+	assert.Panics(t, func() {
+		wide.Group(0xFFFFFFFF).Handle(0x10000, handler)
+	})
+}
+
+func Test_Router_PackCmd(t *testing.T) {
+	data, err := xtcp.PackCmd(0x12345678, []byte("x"), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x12, 0x34, 0x56, 0x78, 'x'}, data)
+
+	_, err = xtcp.PackCmd(0x10000, nil, 2)
+	assert.Error(t, err)
+
+	_, _, err = xtcp.UnpackCmd([]byte{1}, 2)
+	assert.Equal(t, xtcp.ErrRouterCmdShort, err)
+}
@@ -0,0 +1,96 @@
+package xtcp
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Serializer 定义 SendValue/RecvValue 的序列化方式，编码后的数据再由连接的 Codec 分帧
+// 同一个 Serializer 可能被多个连接同时使用，实现需要是并发安全的
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonSerializer struct{}
+
+// gobSerializer 每条消息独立编码，都会带上类型信息，不依赖连接上之前的消息
+type gobSerializer struct{}
+
+// 未设置 Serializer 时使用的默认序列化方式
+var defaultSerializer = NewJSONSerializer()
+
+func NewJSONSerializer() Serializer {
+	return jsonSerializer{}
+}
+
+func NewGobSerializer() Serializer {
+	return gobSerializer{}
+}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (gobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// SetSerializer 设置 SendValue/RecvValue 使用的序列化方式，为 nil 时使用 JSON
+func (c *Conn) SetSerializer(serializer Serializer) {
+	c.serializer = serializer
+}
+
+func (c *Conn) Serializer() Serializer {
+	if c.serializer == nil {
+		return defaultSerializer
+	}
+	return c.serializer
+}
+
+// SendValue 序列化 v 并作为一条消息发送
+func (c *Conn) SendValue(v interface{}, retry ...Retry) error {
+	data, err := c.Serializer().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(data, retry...)
+}
+
+// RecvValue 接收一条消息并反序列化到 v，v 需要是指针
+func (c *Conn) RecvValue(v interface{}) error {
+	data, err := c.RecvMsg()
+	if err != nil {
+		return err
+	}
+	return c.Serializer().Unmarshal(data, v)
+}
+
+func (c *PoolConn) SendValue(v interface{}, retry ...Retry) error {
+	data, err := c.Serializer().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(data, retry...)
+}
+
+func (c *PoolConn) RecvValue(v interface{}) error {
+	data, err := c.RecvMsg()
+	if err != nil {
+		return err
+	}
+	return c.Serializer().Unmarshal(data, v)
+}
@@ -0,0 +1,70 @@
+package xtcp_test
+
+import (
+	"fmt"
+	"github.com/motai3/xtcp"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+type serializerUser struct {
+	Id   int
+	Name string
+	Tags []string
+}
+
+func Test_Serializer_Value(t *testing.T) {
+	for name, serializer := range map[string]xtcp.Serializer{
+		"JSON": xtcp.NewJSONSerializer(),
+		"Gob":  xtcp.NewGobSerializer(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, server := net.Pipe()
+			conn := xtcp.NewConnByNetConn(client)
+			defer conn.Close()
+			conn.SetSerializer(serializer)
+			peer := xtcp.NewConnByNetConn(server)
+			defer peer.Close()
+			peer.SetSerializer(serializer)
+
+			sent := serializerUser{Id: 1, Name: "john", Tags: []string{"a", "b"}}
+			go peer.SendValue(sent)
+			var received serializerUser
+			assert.NoError(t, conn.RecvValue(&received))
+			assert.Equal(t, sent, received)
+		})
+	}
+}
+
+func Test_Serializer_Server(t *testing.T) {
+	p := portList.PopFront().(int)
+
+	server := xtcp.NewServer(fmt.Sprintf(`:%d`, p), func(conn *xtcp.Conn) {
+		defer conn.Close()
+		for {
+			var user serializerUser
+			if err := conn.RecvValue(&user); err != nil {
+				break
+			}
+			user.Id++
+			conn.SendValue(user)
+		}
+	})
+	server.SetSerializer(xtcp.NewGobSerializer())
+	go server.Run()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := xtcp.NewPoolConn(fmt.Sprintf("127.0.0.1:%d", p))
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetSerializer(xtcp.NewGobSerializer())
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, conn.SendValue(serializerUser{Id: i, Name: "john"}))
+		var user serializerUser
+		assert.NoError(t, conn.RecvValue(&user))
+		assert.Equal(t, serializerUser{Id: i + 1, Name: "john"}, user)
+	}
+}
@@ -3,7 +3,7 @@ package xtcp
 import (
 	"crypto/tls"
 	"errors"
-	"fmt"
+	"log"
 	"net"
 	"sync"
 )
@@ -13,11 +13,15 @@ const (
 )
 
 type Server struct {
-	mu        sync.Mutex
-	listen    net.Listener
-	address   string
-	handler   func(*Conn)
-	tlsConfig *tls.Config
+	mu         sync.Mutex
+	listen     net.Listener
+	address    string
+	handler    func(*Conn)
+	tlsConfig  *tls.Config
+	hooks      ConnHooks
+	codec      Codec
+	serializer Serializer
+	heartbeat  *HeartbeatOption
 }
 
 // 跟据名字映射server
@@ -53,7 +57,7 @@ func NewServerTLS(address string, tlsConfig *tls.Config, handler func(*Conn), na
 func NewServerKeyCrt(address, crtFile, keyFile string, handler func(*Conn), name ...string) *Server {
 	s := NewServer(address, handler, name...)
 	if err := s.SetTLSKeyCrt(crtFile, keyFile); err != nil {
-		fmt.Errorf(err.Error())
+		log.Printf("xtcp: load key crt failed: %v", err)
 	}
 	return s
 }
@@ -66,6 +70,46 @@ func (s *Server) SetHandler(handler func(*Conn)) {
 	s.handler = handler
 }
 
+// SetOnConnect 设置新连接回调，返回错误时拒绝并关闭该连接
+func (s *Server) SetOnConnect(fn func(c *Conn) error) {
+	s.hooks.OnConnect = fn
+}
+
+// SetOnClose 设置连接关闭回调，handler 需要关闭连接才会触发
+func (s *Server) SetOnClose(fn func(c *Conn, info CloseInfo)) {
+	s.hooks.OnClose = fn
+}
+
+// SetOnError 设置连接读写错误回调
+func (s *Server) SetOnError(fn func(c *Conn, err error)) {
+	s.hooks.OnError = fn
+}
+
+// SetCodec 设置连接的分帧方式，codec 会被所有连接共享
+// 设置了 PkgOption.Cipher 的 NewPkgCodec 返回 ErrSharedCipher，加密的连接需要在 handler 中调用 Conn.SetCodec
+func (s *Server) SetCodec(codec Codec) error {
+	if p, ok := codec.(*pkgCodec); ok && p.option.Cipher != nil {
+		return ErrSharedCipher
+	}
+	s.codec = codec
+	return nil
+}
+
+// SetSerializer 设置连接 SendValue/RecvValue 的序列化方式
+func (s *Server) SetSerializer(serializer Serializer) {
+	s.serializer = serializer
+}
+
+// SetHeartbeat 为每个连接启动心跳，失联的连接会被关闭，handler 中的读取会返回错误
+// 所有连接共用 option，PkgOption.Cipher 不为 nil 时返回 ErrHeartbeatCipher，加密的连接需要在 handler 中调用 StartHeartbeat
+func (s *Server) SetHeartbeat(option HeartbeatOption) error {
+	if err := checkSharedHeartbeat(&option); err != nil {
+		return err
+	}
+	s.heartbeat = &option
+	return nil
+}
+
 func (s *Server) SetTLSKeyCrt(crtFile, keyFile string) error {
 	tlsConfig, err := LoadKeyCrt(crtFile, keyFile)
 	if err != nil {
@@ -117,7 +161,23 @@ func (s *Server) Run() (err error) {
 		if conn, err := s.listen.Accept(); err != nil {
 			return err
 		} else if conn != nil {
-			go s.handler(NewConnByNetConn(conn))
+			go s.serve(NewConnByNetConn(conn))
+		}
+	}
+}
+
+func (s *Server) serve(conn *Conn) {
+	conn.hooks = s.hooks
+	conn.codec = s.codec
+	conn.serializer = s.serializer
+	if s.heartbeat != nil {
+		if err := conn.StartHeartbeat(*s.heartbeat); err != nil {
+			conn.CloseWithReason(err)
+			return
 		}
 	}
+	if conn.connected() != nil {
+		return
+	}
+	s.handler(conn)
 }